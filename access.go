@@ -0,0 +1,208 @@
+package axion
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"slices"
+)
+
+// Decides who may join a room.
+type RoomAccess int
+
+const (
+	// Anyone may join, knowing the password if the room has one.
+	AccessPublic RoomAccess = iota
+	// Only allowed users or holders of the password may join.
+	AccessPrivate
+	// Only invited users may join. An invitation is used up by joining.
+	AccessInviteOnly
+)
+
+// An action of a client on a room.
+type RoomAction uint8
+
+const (
+	ActionOpen RoomAction = iota + 1
+	ActionJoin
+	ActionSend
+	ActionClose
+	ActionKick
+)
+
+func (a RoomAction) String() string {
+	switch a {
+	case ActionOpen:
+		return "open"
+	case ActionJoin:
+		return "join"
+	case ActionSend:
+		return "send"
+	case ActionClose:
+		return "close"
+	case ActionKick:
+		return "kick"
+	}
+	return "unknown"
+}
+
+// Access rules consulted when clients join, send to, close or kick from a room through protocol messages.
+// Users are identified by the user id of their identity, or by the client id if they are not authenticated.
+// The owner of a room, the client which opened it, may do everything.
+type RoomPolicy struct {
+	Access RoomAccess
+	// Required to join public rooms if set. Grants access to private rooms.
+	Password string
+	// Users which may join a private room.
+	Allow []string
+	// Roles of which the client needs one to perform an action. Actions without an entry are open to all
+	// members, except closing and kicking which are reserved to the owner.
+	Roles map[RoomAction][]string
+}
+
+// Returned when a room policy denies an action.
+type AccessError struct {
+	Action RoomAction
+	RoomId string
+	Reason string
+}
+
+func (e *AccessError) Error() string {
+	return fmt.Sprintf("%s denied: %s", e.Action, e.Reason)
+}
+
+// Returns the id policies know the client by.
+func subjectOf(client *Client) string {
+	if identity := client.Identity(); identity != nil && identity.UserId != "" {
+		return identity.UserId
+	}
+	return client.id
+}
+
+func hasAnyRole(client *Client, roles []string) bool {
+	return slices.ContainsFunc(roles, client.Identity().HasRole)
+}
+
+// Returns the policy of the room.
+func (r *Room) Policy() RoomPolicy {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.policy
+}
+
+// Sets the policy of the room. Clients already in the room stay.
+func (r *Room) SetPolicy(policy RoomPolicy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.policy = policy
+}
+
+// Returns the user id of the owner, empty if the room was created by the server.
+func (r *Room) Owner() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.owner
+}
+
+// Allows the user to join the room once, regardless of its access.
+func (r *Room) Invite(userId string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.invited[userId] = struct{}{}
+}
+
+// Withdraws an invitation.
+func (r *Room) Uninvite(userId string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.invited, userId)
+}
+
+// Checks whether the policy of the room allows the client to perform the action. password is only
+// consulted for joins. Returns an *AccessError if not. A successful join uses up the invitation of the client.
+func (r *Room) Authorize(client *Client, action RoomAction, password []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subject := subjectOf(client)
+	deny := func(reason string) error {
+		return &AccessError{Action: action, RoomId: r.id, Reason: reason}
+	}
+
+	if r.owner != "" && subject == r.owner {
+		return nil
+	}
+	roles, restricted := r.policy.Roles[action]
+	if restricted && !hasAnyRole(client, roles) {
+		return deny("missing role")
+	}
+	if (action == ActionClose || action == ActionKick) && !restricted {
+		return deny("only the owner may " + action.String())
+	}
+	if action != ActionJoin {
+		return nil
+	}
+
+	if _, ok := r.invited[subject]; ok {
+		delete(r.invited, subject)
+		return nil
+	}
+	passwordOk := r.policy.Password != "" && subtle.ConstantTimeCompare(password, []byte(r.policy.Password)) == 1
+	switch r.policy.Access {
+	case AccessPublic:
+		if r.policy.Password != "" && !passwordOk {
+			return deny("wrong password")
+		}
+	case AccessPrivate:
+		if !slices.Contains(r.policy.Allow, subject) && !passwordOk {
+			return deny("not allowed")
+		}
+	case AccessInviteOnly:
+		return deny("not invited")
+	}
+	return nil
+}
+
+// Removes the client from the room. The client and the members receive a ClientLeft message.
+func (r *Room) Kick(client *Client) {
+	if _, ok := client.GetRoom(r.id); !ok {
+		return
+	}
+	r.hub.server.options.logger.Debug("client kicked", "room_id", r.id, "client_id", client.id)
+	client.LeaveRoom(r)
+}
+
+// Sets the policy of rooms clients open in the namespace. Its Roles entry for ActionOpen restricts
+// which clients may open rooms.
+func (ns *Namespace) SetRoomPolicy(policy RoomPolicy) {
+	ns.hub.mu.Lock()
+	defer ns.hub.mu.Unlock()
+	ns.roomPolicy = policy
+}
+
+// Opens a room on behalf of the client, which becomes its owner.
+func (ns *Namespace) openRoom(client *Client) (*Room, error) {
+	ns.hub.mu.RLock()
+	policy := ns.roomPolicy
+	ns.hub.mu.RUnlock()
+
+	if roles, ok := policy.Roles[ActionOpen]; ok && !hasAnyRole(client, roles) {
+		return nil, &AccessError{Action: ActionOpen, Reason: "missing role"}
+	}
+	room := ns.hub.createRoom()
+	room.mu.Lock()
+	room.policy = policy
+	room.owner = subjectOf(client)
+	room.mu.Unlock()
+	return room, nil
+}
+
+// Reports a denied action to the client.
+func (c *Client) accessDenied(err error) {
+	accessErr, ok := err.(*AccessError)
+	if !ok {
+		c.clientError(err.Error())
+		return
+	}
+	c.logger.Debug("room access denied", "room_id", accessErr.RoomId, "action", accessErr.Action.String(), "reason", accessErr.Reason)
+	c.SendMessage(NewAccessDeniedMessage(accessErr))
+}
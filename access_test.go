@@ -0,0 +1,65 @@
+package axion
+
+import "testing"
+
+func TestRoomAuthorize(t *testing.T) {
+	s := NewServer()
+	client := func(userId string, roles ...string) *Client {
+		c := newClient(s.hub, nil, userId+"-client")
+		c.identity = &Identity{UserId: userId, Roles: roles}
+		return c
+	}
+	owner, alice, mod := client("owner"), client("alice"), client("mod", "moderator")
+
+	tests := []struct {
+		name     string
+		policy   RoomPolicy
+		client   *Client
+		action   RoomAction
+		password string
+		allowed  bool
+	}{
+		{"public join", RoomPolicy{}, alice, ActionJoin, "", true},
+		{"password missing", RoomPolicy{Password: "pw"}, alice, ActionJoin, "", false},
+		{"password given", RoomPolicy{Password: "pw"}, alice, ActionJoin, "pw", true},
+		{"private not allowed", RoomPolicy{Access: AccessPrivate}, alice, ActionJoin, "", false},
+		{"private allowed", RoomPolicy{Access: AccessPrivate, Allow: []string{"alice"}}, alice, ActionJoin, "", true},
+		{"invite only", RoomPolicy{Access: AccessInviteOnly}, alice, ActionJoin, "", false},
+		{"owner bypasses", RoomPolicy{Access: AccessInviteOnly}, owner, ActionJoin, "", true},
+		{"kick reserved to owner", RoomPolicy{}, alice, ActionKick, "", false},
+		{"kick by role", RoomPolicy{Roles: map[RoomAction][]string{ActionKick: {"moderator"}}}, mod, ActionKick, "", true},
+		{"send missing role", RoomPolicy{Roles: map[RoomAction][]string{ActionSend: {"speaker"}}}, alice, ActionSend, "", false},
+		{"close reserved to owner", RoomPolicy{}, alice, ActionClose, "", false},
+		{"close by owner", RoomPolicy{}, owner, ActionClose, "", true},
+		{"close by role", RoomPolicy{Roles: map[RoomAction][]string{ActionClose: {"moderator"}}}, mod, ActionClose, "", true},
+	}
+
+	for _, test := range tests {
+		room := newRoom("room", s.hub)
+		room.owner = "owner"
+		room.SetPolicy(test.policy)
+		err := room.Authorize(test.client, test.action, []byte(test.password))
+		if (err == nil) != test.allowed {
+			t.Errorf("%s: got error %v, want allowed %v", test.name, err, test.allowed)
+		}
+	}
+
+	room := newRoom("room", s.hub)
+	room.SetPolicy(RoomPolicy{Access: AccessInviteOnly})
+	room.Invite("alice")
+	if err := room.Authorize(alice, ActionJoin, nil); err != nil {
+		t.Errorf("invited join: got error %v", err)
+	}
+	if err := room.Authorize(alice, ActionJoin, nil); err == nil {
+		t.Error("invitation was not used up")
+	}
+}
+
+func TestServerRoomCloseDenied(t *testing.T) {
+	s := NewServer()
+	room := s.CreateRoom()
+	c := newClient(s.hub, nil, "alice-client")
+	if err := room.Authorize(c, ActionClose, nil); err == nil {
+		t.Error("member may close a room created by the server")
+	}
+}
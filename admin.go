@@ -0,0 +1,279 @@
+package axion
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type adminClient struct {
+	Id          string    `json:"id"`
+	RemoteAddr  string    `json:"remote_addr"`
+	Rooms       []string  `json:"rooms"`
+	ConnectedAt time.Time `json:"connected_at"`
+	QueueDepth  int       `json:"queue_depth"`
+	Detached    bool      `json:"detached"`
+}
+
+type adminRoom struct {
+	Id      string   `json:"id"`
+	Members []string `json:"members"`
+}
+
+type adminMessage struct {
+	Text   *string `json:"text"`
+	Binary []byte  `json:"binary"`
+}
+
+type adminClose struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// Returns a handler for the admin API. Every request has to pass authorize, a nil authorize rejects all requests.
+// Requests act on the default namespace unless the query parameter namespace names another one.
+//
+//	GET    /clients                        list clients
+//	GET    /clients/{id}                   get a client
+//	POST   /clients/{id}/kick              close the connection, body {"code": 4000, "reason": "..."}
+//	PUT    /clients/{id}/rooms/{roomId}    force the client into the room
+//	DELETE /clients/{id}/rooms/{roomId}    force the client out of the room
+//	GET    /rooms                          list rooms with members
+//	GET    /rooms/{id}                     get a room
+//	DELETE /rooms/{id}                     close the room
+//	POST   /rooms/{id}/broadcast           broadcast to the room, body {"text": "..."} or {"binary": "<base64>"}
+//	POST   /broadcast                      broadcast to all clients
+//
+// Mount it with http.StripPrefix when serving it below a path.
+func (s *Server) AdminHandler(authorize func(r *http.Request) bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /clients", s.adminListClients)
+	mux.HandleFunc("GET /clients/{id}", s.adminGetClient)
+	mux.HandleFunc("POST /clients/{id}/kick", s.adminKickClient)
+	mux.HandleFunc("PUT /clients/{id}/rooms/{roomId}", s.adminJoinRoom)
+	mux.HandleFunc("DELETE /clients/{id}/rooms/{roomId}", s.adminLeaveRoom)
+	mux.HandleFunc("GET /rooms", s.adminListRooms)
+	mux.HandleFunc("GET /rooms/{id}", s.adminGetRoom)
+	mux.HandleFunc("DELETE /rooms/{id}", s.adminCloseRoom)
+	mux.HandleFunc("POST /rooms/{id}/broadcast", s.adminBroadcastRoom)
+	mux.HandleFunc("POST /broadcast", s.adminBroadcast)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorize == nil || !authorize(r) {
+			writeAdminError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func (s *Server) adminNamespace(w http.ResponseWriter, r *http.Request) (*Namespace, bool) {
+	name := r.URL.Query().Get("namespace")
+	if name == "" {
+		name = DefaultNamespace
+	}
+	ns, ok := s.getNamespace(name)
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, "namespace not found")
+	}
+	return ns, ok
+}
+
+func (s *Server) adminClient(w http.ResponseWriter, r *http.Request) (*Client, bool) {
+	ns, ok := s.adminNamespace(w, r)
+	if !ok {
+		return nil, false
+	}
+	client, ok := ns.GetClientById(r.PathValue("id"))
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, "client not found")
+	}
+	return client, ok
+}
+
+func (s *Server) adminRoom(w http.ResponseWriter, r *http.Request, id string) (*Room, bool) {
+	ns, ok := s.adminNamespace(w, r)
+	if !ok {
+		return nil, false
+	}
+	room, ok := ns.GetRoomById(id)
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, "room not found")
+	}
+	return room, ok
+}
+
+func describeClient(client *Client) adminClient {
+	rooms := client.Rooms()
+	ids := make([]string, len(rooms))
+	for i, room := range rooms {
+		ids[i] = room.id
+	}
+	return adminClient{
+		Id:          client.id,
+		RemoteAddr:  client.RemoteAddr().String(),
+		Rooms:       ids,
+		ConnectedAt: client.ConnectedAt(),
+		QueueDepth:  client.Connection().QueueLen(),
+		Detached:    client.Connection().Detached(),
+	}
+}
+
+func describeRoom(room *Room) adminRoom {
+	members := room.Members()
+	ids := make([]string, len(members))
+	for i, client := range members {
+		ids[i] = client.id
+	}
+	return adminRoom{Id: room.id, Members: ids}
+}
+
+func readAdminMessage(w http.ResponseWriter, r *http.Request) (WsMessage, bool) {
+	var body adminMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAdminError(w, http.StatusBadRequest, "invalid body")
+		return WsMessage{}, false
+	}
+	if body.Text != nil {
+		return NewTextMesssage(*body.Text), true
+	}
+	return NewBinaryMessage(body.Binary), true
+}
+
+func (s *Server) adminListClients(w http.ResponseWriter, r *http.Request) {
+	ns, ok := s.adminNamespace(w, r)
+	if !ok {
+		return
+	}
+	clients := ns.Clients()
+	result := make([]adminClient, len(clients))
+	for i, client := range clients {
+		result[i] = describeClient(client)
+	}
+	slices.SortFunc(result, func(a, b adminClient) int { return a.ConnectedAt.Compare(b.ConnectedAt) })
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) adminGetClient(w http.ResponseWriter, r *http.Request) {
+	if client, ok := s.adminClient(w, r); ok {
+		writeJSON(w, http.StatusOK, describeClient(client))
+	}
+}
+
+func (s *Server) adminKickClient(w http.ResponseWriter, r *http.Request) {
+	client, ok := s.adminClient(w, r)
+	if !ok {
+		return
+	}
+	body := adminClose{Code: websocket.ClosePolicyViolation, Reason: "kicked"}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeAdminError(w, http.StatusBadRequest, "invalid body")
+			return
+		}
+	}
+	s.options.logger.Info("kicking client", "client_id", client.id, "code", body.Code)
+	client.Kick(body.Code, body.Reason)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) adminJoinRoom(w http.ResponseWriter, r *http.Request) {
+	client, ok := s.adminClient(w, r)
+	if !ok {
+		return
+	}
+	room, ok := s.adminRoom(w, r, r.PathValue("roomId"))
+	if !ok {
+		return
+	}
+	if _, ok := client.GetRoom(room.id); !ok {
+		client.JoinRoom(room)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) adminLeaveRoom(w http.ResponseWriter, r *http.Request) {
+	client, ok := s.adminClient(w, r)
+	if !ok {
+		return
+	}
+	room, ok := client.GetRoom(r.PathValue("roomId"))
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, "client is not in the room")
+		return
+	}
+	client.LeaveRoom(room)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) adminListRooms(w http.ResponseWriter, r *http.Request) {
+	ns, ok := s.adminNamespace(w, r)
+	if !ok {
+		return
+	}
+	rooms := ns.Rooms()
+	result := make([]adminRoom, len(rooms))
+	for i, room := range rooms {
+		result[i] = describeRoom(room)
+	}
+	slices.SortFunc(result, func(a, b adminRoom) int {
+		if a.Id < b.Id {
+			return -1
+		}
+		if a.Id > b.Id {
+			return 1
+		}
+		return 0
+	})
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) adminGetRoom(w http.ResponseWriter, r *http.Request) {
+	if room, ok := s.adminRoom(w, r, r.PathValue("id")); ok {
+		writeJSON(w, http.StatusOK, describeRoom(room))
+	}
+}
+
+func (s *Server) adminCloseRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := s.adminRoom(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	room.Close()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) adminBroadcastRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := s.adminRoom(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	if message, ok := readAdminMessage(w, r); ok {
+		room.BroadcastMessage(message)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) adminBroadcast(w http.ResponseWriter, r *http.Request) {
+	ns, ok := s.adminNamespace(w, r)
+	if !ok {
+		return
+	}
+	if message, ok := readAdminMessage(w, r); ok {
+		ns.BroadcastMessage(message)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
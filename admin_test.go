@@ -0,0 +1,128 @@
+package axion
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestAdminHandler(t *testing.T) {
+	s := NewServer()
+	lobby := s.CreateRoom().Id()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	admin := httptest.NewServer(s.AdminHandler(func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer admin"
+	}))
+	defer admin.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	id, _ := readInit(t, conn)
+
+	call := func(method string, path string, body string, authorized bool) (int, string) {
+		r, _ := http.NewRequest(method, admin.URL+path, strings.NewReader(body))
+		if authorized {
+			r.Header.Set("Authorization", "Bearer admin")
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		content, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, strings.TrimSpace(string(content))
+	}
+
+	if status, body := call("GET", "/clients", "", false); status != http.StatusForbidden || body != `{"error":"forbidden"}` {
+		t.Errorf("unauthorized: got %d %s", status, body)
+	}
+
+	status, body := call("GET", "/clients/"+id, "", true)
+	var client adminClient
+	if err := json.Unmarshal([]byte(body), &client); err != nil || status != http.StatusOK || client.Id != id || client.Detached {
+		t.Errorf("get client: got %d %s", status, body)
+	}
+	status, body = call("GET", "/clients", "", true)
+	var clients []adminClient
+	if err := json.Unmarshal([]byte(body), &clients); err != nil || status != http.StatusOK || len(clients) != 1 || clients[0].Id != id {
+		t.Errorf("list clients: got %d %s", status, body)
+	}
+	if status, body := call("GET", "/clients/missing", "", true); status != http.StatusNotFound || body != `{"error":"client not found"}` {
+		t.Errorf("missing client: got %d %s", status, body)
+	}
+	if status, body := call("GET", "/rooms?namespace=/missing", "", true); status != http.StatusNotFound || body != `{"error":"namespace not found"}` {
+		t.Errorf("missing namespace: got %d %s", status, body)
+	}
+
+	// A member without a connection keeps every message in its queue.
+	member := newClient(s.hub, nil, "member")
+	s.hub.mu.Lock()
+	s.hub.clients[member.id] = member
+	s.hub.mu.Unlock()
+	queued := func() []WsMessage {
+		messages, _ := member.queue.drain()
+		return messages
+	}
+
+	if status, _ := call("PUT", "/clients/member/rooms/"+lobby, "", true); status != http.StatusNoContent {
+		t.Errorf("join room: got %d", status)
+	}
+	if status, body := call("GET", "/rooms", "", true); status != http.StatusOK || body != `[{"id":"`+lobby+`","members":["member"]}]` {
+		t.Errorf("list rooms: got %d %s", status, body)
+	}
+	queued()
+
+	if status, _ := call("POST", "/rooms/"+lobby+"/broadcast", `{"text":"hello"}`, true); status/100 != 2 {
+		t.Errorf("broadcast: got %d", status)
+	}
+	if messages := queued(); len(messages) != 1 || string(messages[0].content) != "hello" {
+		t.Errorf("broadcast: member got %v", messages)
+	}
+	if status, body := call("POST", "/rooms/"+lobby+"/broadcast", `{`, true); status != http.StatusBadRequest || body != `{"error":"invalid body"}` {
+		t.Errorf("invalid broadcast: got %d %s", status, body)
+	}
+
+	if status, _ := call("DELETE", "/clients/member/rooms/"+lobby, "", true); status != http.StatusNoContent {
+		t.Errorf("leave room: got %d", status)
+	}
+	if status, body := call("DELETE", "/clients/member/rooms/"+lobby, "", true); status != http.StatusNotFound || body != `{"error":"client is not in the room"}` {
+		t.Errorf("leave room twice: got %d %s", status, body)
+	}
+	if status, _ := call("DELETE", "/rooms/"+lobby, "", true); status != http.StatusNoContent {
+		t.Errorf("close room: got %d", status)
+	}
+	if status, body := call("GET", "/rooms/"+lobby, "", true); status != http.StatusNotFound || body != `{"error":"room not found"}` {
+		t.Errorf("closed room: got %d %s", status, body)
+	}
+
+	s.hub.mu.Lock()
+	delete(s.hub.clients, member.id)
+	s.hub.mu.Unlock()
+
+	if status, _ := call("POST", "/broadcast", `{"text":"everyone"}`, true); status != http.StatusNoContent {
+		t.Errorf("broadcast all: got %d", status)
+	}
+	if msgType, p, _ := conn.ReadMessage(); msgType != websocket.TextMessage || string(p) != "everyone" {
+		t.Errorf("broadcast all: client got %q", p)
+	}
+
+	if status, _ := call("POST", "/clients/"+id+"/kick", `{"code":4001,"reason":"bye"}`, true); status != http.StatusNoContent {
+		t.Errorf("kick: got %d", status)
+	}
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != 4001 || closeErr.Text != "bye" {
+		t.Errorf("kick: client got %v", err)
+	}
+	expectMetric(t, s, `axion_disconnects_total{reason="kicked"}`, 1)
+}
@@ -0,0 +1,292 @@
+package axion
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The authenticated party behind a connection.
+type Identity struct {
+	UserId string
+	Roles  []string
+	Claims map[string]any
+}
+
+// Reports whether the identity has the given role.
+func (i *Identity) HasRole(role string) bool {
+	return i != nil && slices.Contains(i.Roles, role)
+}
+
+// Authenticates upgrade requests before the connection is upgraded.
+type Authenticator interface {
+	// Returns the identity of the request or an error rejecting it. A *AuthError sets the HTTP status
+	// of the rejection, any other error rejects with 401.
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// Adapts a function to the Authenticator interface.
+type AuthenticatorFunc func(r *http.Request) (*Identity, error)
+
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (*Identity, error) {
+	return f(r)
+}
+
+// Rejects an upgrade request with an HTTP status.
+type AuthError struct {
+	Status  int
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	return e.Message
+}
+
+// Returns an error rejecting an upgrade request with the given status and message.
+func Reject(status int, message string) error {
+	return &AuthError{Status: status, Message: message}
+}
+
+var (
+	ErrMissingToken = Reject(http.StatusUnauthorized, "missing token")
+	ErrInvalidToken = Reject(http.StatusUnauthorized, "invalid token")
+	ErrTokenExpired = Reject(http.StatusUnauthorized, "token expired")
+)
+
+// Authenticates every upgrade request before it reaches the upgrade handler. Rejected requests
+// are answered with the status of the error. The identity is stored on the client and on the request context.
+func WithAuthenticator(a Authenticator) Option {
+	return func(o *serverOptions) {
+		o.authenticator = a
+	}
+}
+
+type identityKey struct{}
+
+// Returns the identity an authenticator attached to the context of an upgrade request.
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	return identity, ok
+}
+
+// Runs the authenticator of the server. Returns the request carrying the identity in its context,
+// or false after responding with the rejection.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if s.options.authenticator == nil {
+		return r, true
+	}
+	identity, err := s.options.authenticator.Authenticate(r)
+	if err != nil {
+		status := http.StatusUnauthorized
+		var authErr *AuthError
+		if errors.As(err, &authErr) {
+			status = authErr.Status
+		}
+		s.options.logger.Debug("authentication failed", "remote_addr", r.RemoteAddr, "error", err)
+		s.upgradeError(w, r, status, err)
+		return r, false
+	}
+	if identity == nil {
+		return r, true
+	}
+	return r.WithContext(context.WithValue(r.Context(), identityKey{}, identity)), true
+}
+
+// Returns the identity of the client, nil if the server has no authenticator.
+func (c *Client) Identity() *Identity {
+	return c.Connection().identity
+}
+
+// A session may only be resumed by the user it belongs to.
+func sameUser(a, b *Identity) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.UserId == b.UserId
+}
+
+// Extracts a token from an upgrade request.
+type TokenSource func(r *http.Request) (string, bool)
+
+// Reads the token from a header. A "Bearer " prefix is stripped.
+func TokenFromHeader(name string) TokenSource {
+	return func(r *http.Request) (string, bool) {
+		value := r.Header.Get(name)
+		if len(value) > 7 && strings.EqualFold(value[:7], "bearer ") {
+			value = value[7:]
+		}
+		return value, value != ""
+	}
+}
+
+// Reads the token from a query parameter.
+func TokenFromQuery(param string) TokenSource {
+	return func(r *http.Request) (string, bool) {
+		value := r.URL.Query().Get(param)
+		return value, value != ""
+	}
+}
+
+// Reads the token from an offered subprotocol of the form prefix + token, which lets browsers send a token
+// without query parameters. Browsers require the server to select one of the offered subprotocols, so
+// clients should offer an application protocol next to the token which is listed in WithSubprotocols.
+func TokenFromProtocol(prefix string) TokenSource {
+	return func(r *http.Request) (string, bool) {
+		for _, protocol := range websocket.Subprotocols(r) {
+			if token, ok := strings.CutPrefix(protocol, prefix); ok && token != "" {
+				return token, true
+			}
+		}
+		return "", false
+	}
+}
+
+// Configures a JWTAuthenticator.
+type JWTOptions struct {
+	// Key the tokens are signed with. Required.
+	Secret []byte
+	// Tried in order. Defaults to the Authorization header, the query parameter "token" and
+	// subprotocols prefixed with "bearer.".
+	Sources []TokenSource
+	// Required "iss" claim if set.
+	Issuer string
+	// Required entry of the "aud" claim if set.
+	Audience string
+	// Claim holding the roles of the identity. Defaults to "roles".
+	RolesClaim string
+	// Tolerated clock skew for "exp" and "nbf".
+	Leeway time.Duration
+}
+
+// Authenticates requests with HMAC-SHA256 signed JSON web tokens (HS256). The "sub" claim becomes the
+// user id of the identity.
+type JWTAuthenticator struct {
+	options JWTOptions
+	now     func() time.Time
+}
+
+// Panics if the secret is empty, anyone could sign tokens otherwise.
+func NewJWTAuthenticator(opts JWTOptions) *JWTAuthenticator {
+	if len(opts.Secret) == 0 {
+		panic("axion: empty JWT secret")
+	}
+	if opts.Sources == nil {
+		opts.Sources = []TokenSource{
+			TokenFromHeader("Authorization"),
+			TokenFromQuery("token"),
+			TokenFromProtocol("bearer."),
+		}
+	}
+	if opts.RolesClaim == "" {
+		opts.RolesClaim = "roles"
+	}
+	return &JWTAuthenticator{options: opts, now: time.Now}
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	for _, source := range a.options.Sources {
+		if token, ok := source(r); ok {
+			return a.Verify(token)
+		}
+	}
+	return nil, ErrMissingToken
+}
+
+// Checks the signature and the registered claims of a token and returns its identity.
+func (a *JWTAuthenticator) Verify(token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	mac := hmac.New(sha256.New, a.options.Secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	now := a.now()
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(a.options.Leeway)) {
+		return nil, ErrTokenExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0).Add(-a.options.Leeway)) {
+		return nil, ErrInvalidToken
+	}
+	if a.options.Issuer != "" && claims["iss"] != a.options.Issuer {
+		return nil, ErrInvalidToken
+	}
+	if a.options.Audience != "" && !slices.Contains(stringClaims(claims["aud"]), a.options.Audience) {
+		return nil, ErrInvalidToken
+	}
+
+	subject, _ := claims["sub"].(string)
+	return &Identity{
+		UserId: subject,
+		Roles:  stringClaims(claims[a.options.RolesClaim]),
+		Claims: claims,
+	}, nil
+}
+
+// Returns a HS256 signed token carrying the given claims.
+func SignJWT(secret []byte, claims map[string]any) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func decodeSegment(segment string, v any) error {
+	p, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(p, v)
+}
+
+// Reads a claim which is either a string or an array of strings.
+func stringClaims(claim any) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, value := range v {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package axion
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJWTAuthenticator(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1700000000, 0)
+	sign := func(secret []byte, claims map[string]any) string {
+		token, err := SignJWT(secret, claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := sign(secret, map[string]any{"sub": "alice", "roles": []string{"admin"}, "aud": "chat", "exp": now.Unix() + 60})
+	parts := strings.Split(valid, ".")
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"valid", valid, nil},
+		{"wrong secret", sign([]byte("other"), map[string]any{"sub": "alice", "aud": "chat"}), ErrInvalidToken},
+		{"expired", sign(secret, map[string]any{"sub": "alice", "aud": "chat", "exp": now.Unix() - 60}), ErrTokenExpired},
+		{"not yet valid", sign(secret, map[string]any{"sub": "alice", "aud": "chat", "nbf": now.Unix() + 60}), ErrInvalidToken},
+		{"wrong audience", sign(secret, map[string]any{"sub": "alice", "aud": "other"}), ErrInvalidToken},
+		{"alg none", "eyJhbGciOiJub25lIn0." + parts[1] + ".", ErrInvalidToken},
+		{"malformed", "abc", ErrInvalidToken},
+	}
+
+	a := NewJWTAuthenticator(JWTOptions{Secret: secret, Audience: "chat"})
+	a.now = func() time.Time { return now }
+	for _, test := range tests {
+		identity, err := a.Verify(test.token)
+		if err != test.err {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if err == nil && (identity.UserId != "alice" || !identity.HasRole("admin")) {
+			t.Errorf("%s: got identity %+v", test.name, identity)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/ws", nil)
+	r.Header.Set("Sec-WebSocket-Protocol", "axion, bearer."+valid)
+	if identity, err := a.Authenticate(r); err != nil || identity.UserId != "alice" {
+		t.Errorf("subprotocol token: got %+v, %v", identity, err)
+	}
+	if _, err := a.Authenticate(httptest.NewRequest("GET", "/ws", nil)); err != ErrMissingToken {
+		t.Errorf("no token: got error %v, want %v", err, ErrMissingToken)
+	}
+}
+
+func TestJWTAuthenticatorEmptySecret(t *testing.T) {
+	for _, secret := range [][]byte{nil, {}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("secret %q accepted", secret)
+				}
+			}()
+			NewJWTAuthenticator(JWTOptions{Secret: secret})
+		}()
+	}
+}
@@ -1,90 +1,195 @@
 package axion
 
 import (
-	axlog "axion/log"
 	"context"
-	"log"
+	"log/slog"
 	"net"
+	"net/http"
 	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// Handlers of a client. Every Handle* method of Client returns a function removing the handler again. Handlers
+// can be registered and removed from any go routine, including from handlers, and take effect with the next message.
 type ClientHandlers struct {
-	textHandlers        []func(a string)
-	binaryHandlers      []func(p []byte)
-	closeHandlers       []func(p []byte)
-	pingHandlers        []func(p []byte)
-	pongHandlers        []func(p []byte)
-	broadcastHandlers   []func(message []byte)
-	roomMessageHandlers []func(roomId string, message []byte)
-	joinHandlers        []func(roomId string, rest []byte)
-	leaveHandlers       []func(roomId string, rest []byte)
-	openRoomHandlers    []func(joinAfterwards bool, rest []byte)
-	closeRoomHandlers   []func(roomId string, rest []byte)
-	disconnectHandler   func()
+	textHandlers        handlerList[func(a string)]
+	binaryHandlers      handlerList[func(p []byte)]
+	closeHandlers       handlerList[func(p []byte)]
+	pingHandlers        handlerList[func(p []byte)]
+	pongHandlers        handlerList[func(p []byte)]
+	broadcastHandlers   handlerList[func(message []byte)]
+	roomMessageHandlers handlerList[func(roomId string, message []byte)]
+	joinHandlers        handlerList[func(roomId string, rest []byte)]
+	leaveHandlers       handlerList[func(roomId string, rest []byte)]
+	openRoomHandlers    handlerList[func(joinAfterwards bool, rest []byte)]
+	kickHandlers        handlerList[func(roomId string, clientId string)]
+	closeRoomHandlers   handlerList[func(roomId string, rest []byte)]
+	requestHandlers     handlerMap[func(ctx context.Context, payload []byte) ([]byte, error)]
+	eventHandlers       handlerMap[eventHandler]
+	disconnectHandlers  handlerList[func(status CloseStatus)]
+	resumeHandlers      handlerList[func()]
 }
 
 type Client struct {
-	id       string
-	hub      *Hub
-	conn     *websocket.Conn
-	send     chan WsMessage
-	rooms    []*Room
-	handlers *ClientHandlers
-	ctx      context.Context
-	mu       sync.RWMutex
+	id          string
+	hub         *Hub
+	conn        *websocket.Conn
+	queue       *sendQueue
+	attach      chan *websocket.Conn
+	done        chan struct{}
+	closeFrame  []byte
+	endReason   string
+	rooms       []*Room
+	handlers    *ClientHandlers
+	ctx         context.Context
+	resumeToken string
+	detached    bool
+	graceTimer  *time.Timer
+	request     *http.Request
+	identity    *Identity
+	parent      *Client
+	streamId    uint32
+	logger      *slog.Logger
+	stats       clientStats
+	limiter     clientLimiter
+	latency     atomic.Int64
+	lastPing    atomic.Int64
+	closeStatus CloseStatus
+	closing     atomic.Bool
+	version     atomic.Int32
+	negotiated  atomic.Bool
+	features    atomic.Uint32
+	codec       atomic.Pointer[Codec]
+	connectedAt time.Time
+	middleware  handlerList[Middleware]
+	streams     map[uint32]*Client
+	pending     map[uint32]chan reply
+	requests    chan struct{}
+	requestId   atomic.Uint32
+	mu          sync.RWMutex
 }
 
 func newClient(hub *Hub, conn *websocket.Conn, id string) *Client {
 	c := &Client{
-		hub:      hub,
-		conn:     conn,
-		send:     make(chan WsMessage),
-		rooms:    make([]*Room, 0),
-		id:       id,
-		handlers: new(ClientHandlers),
-	}
-	c.handlers.disconnectHandler = func() {}
+		hub:         hub,
+		conn:        conn,
+		queue:       newSendQueue(),
+		attach:      make(chan *websocket.Conn),
+		done:        make(chan struct{}),
+		rooms:       make([]*Room, 0),
+		streams:     make(map[uint32]*Client),
+		pending:     make(map[uint32]chan reply),
+		requests:    make(chan struct{}, hub.server.options.maxRequests),
+		id:          id,
+		handlers:    new(ClientHandlers),
+		connectedAt: time.Now(),
+		limiter:     clientLimiter{kinds: make(map[int]*bucket)},
+	}
+	c.version.Store(ProtocolV1)
+	c.logger = hub.server.options.logger.With("client_id", id)
+	if conn != nil {
+		c.logger = c.logger.With("remote_addr", conn.RemoteAddr().String())
+	}
 	return c
 }
 
-func (c *Client) readPump() {
+func (c *Client) readPump(conn *websocket.Conn) {
+	var status CloseStatus
+	var reason string
 	defer func() {
-		c.hub.unregister <- c
-		c.conn.Close()
+		conn.Close()
+		select {
+		case c.hub.unregister <- &UnregisterClient{client: c, conn: conn, reason: reason, status: status}:
+		case <-c.hub.done:
+		}
 	}()
+	c.watchConnection(conn)
 	for {
-		if err := c.readMessage(); err != nil {
-			axlog.Logln("readPump error: ", err)
+		if err := c.readMessage(conn); err != nil {
+			status, reason = readCloseStatus(err)
+			if reason == "timeout" && !c.closing.Load() {
+				c.logger.Info("peer stopped responding", "pong_wait", c.hub.server.options.pongWait)
+			} else {
+				c.logger.Debug("read failed", "error", err)
+			}
 			break
 		}
+		c.extendReadDeadline(conn)
 	}
 }
 
+// Runs for the whole session of the client. Connections are handed over through the attach channel,
+// a nil connection detaches the client. Messages which can not be written are kept for replay.
+// Once the send queue is closed the pending messages are flushed and the close frame is written.
 func (c *Client) writePump() {
-	ticker := time.NewTicker(60 * time.Second)
+	opts := c.hub.server.options
+	ticker := time.NewTicker(opts.pingPeriod)
 	defer func() {
 		ticker.Stop()
-		c.hub.unregister <- c
-		c.conn.Close()
+		close(c.done)
 	}()
+
+	var conn *websocket.Conn
+	var replay []WsMessage
+
+	write := func(message WsMessage) {
+		if conn != nil {
+			msgType, content, err := message.encode(c.Codec())
+			if err != nil {
+				c.logger.Warn("message can not be encoded", "error", err)
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(opts.writeTimeout))
+			err = conn.WriteMessage(msgType, content)
+			if err == nil {
+				c.countSent(len(content))
+				return
+			}
+			c.logger.Debug("write failed", "error", err)
+			conn.Close()
+			conn = nil
+		}
+		replay = c.bufferReplay(replay, message)
+	}
+
 	for {
 		select {
-		case message, ok := <-c.send:
-			if !ok {
-				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
+		case <-c.queue.ready:
+			messages, closed := c.queue.drain()
+			for _, message := range messages {
+				write(message)
 			}
-			if err := c.conn.WriteMessage(message.msgType, message.content); err != nil {
-				log.Println("writePump error:", err)
+			if closed {
+				if conn != nil {
+					c.mu.RLock()
+					closeFrame := c.closeFrame
+					c.mu.RUnlock()
+					c.closeConnection(conn, closeFrame)
+				}
 				return
 			}
+		case conn = <-c.attach:
+			if conn == nil {
+				continue
+			}
+			write(c.initMessage())
+			pending := replay
+			replay = nil
+			for _, message := range pending {
+				write(message)
+			}
 		case <-ticker.C:
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				return
+			if conn == nil {
+				continue
+			}
+			if err := c.ping(conn); err != nil {
+				c.logger.Debug("ping failed", "error", err)
+				conn.Close()
+				conn = nil
 			}
 		}
 	}
@@ -95,6 +200,11 @@ func (c *Client) Id() string {
 	return c.id
 }
 
+// Returns the time the session of the client started.
+func (c *Client) ConnectedAt() time.Time {
+	return c.connectedAt
+}
+
 // Returns all rooms containing the client.
 func (c *Client) Rooms() []*Room {
 	c.mu.RLock()
@@ -116,51 +226,167 @@ func (c *Client) GetRoom(id string) (*Room, bool) {
 
 // Sends a message with the given websocket message type and content to the client.
 func (c *Client) Send(msgType int, content []byte) {
-	c.send <- NewMessage(msgType, content)
+	c.SendMessage(NewMessage(msgType, content))
 }
 
-// Sends a message to the client.
+// Sends a message to the client. The message is queued, if the queue is full the configured slow consumer policy applies.
 func (c *Client) SendMessage(message WsMessage) {
-	c.send <- message
+	if c.parent != nil {
+		wrapped, err := newStreamMessage(c.streamId, message, c.Codec())
+		if err != nil {
+			c.logger.Warn("message can not be encoded", "error", err)
+			return
+		}
+		c.parent.SendMessage(wrapped)
+		return
+	}
+	opts := &c.hub.server.options.sendQueue
+	dropped, disconnect := c.queue.push(message, opts)
+	if disconnect {
+		c.logger.Warn("disconnecting slow consumer", "queue_size", opts.Size)
+		c.terminate(opts.CloseCode, opts.CloseReason, "slow_consumer")
+	}
+	if dropped != nil {
+		c.hub.server.metrics.dropped[opts.Policy].Add(1)
+		if !disconnect {
+			c.logger.Warn("message dropped", "policy", opts.Policy)
+		}
+		c.protect("slow_consumer", func() {
+			c.hub.server.handlers.slowConsumerHandler(c, opts.Policy, *dropped)
+		})
+	}
 }
 
-// Sends a message with the specified close reason and close code. Leaves all rooms and closes the connection.
+func (c *Client) countSent(size int) {
+	c.stats.messagesSent.Add(1)
+	c.stats.bytesSent.Add(uint64(size))
+	c.hub.server.metrics.messagesSent.Add(1)
+	c.hub.server.metrics.bytesSent.Add(uint64(size))
+}
+
+func (c *Client) countReceived(kind string, size int) {
+	stats := &c.Connection().stats
+	stats.messagesReceived.Add(1)
+	stats.bytesReceived.Add(uint64(size))
+	c.hub.server.metrics.messageReceived(kind, size)
+}
+
+// Returns the number of messages waiting to be written to the client.
+func (c *Client) QueueLen() int {
+	return c.queue.len()
+}
+
+// Closes the connection with the RFC 6455 status code and reason once the queued messages are written, then leaves all rooms.
+// The client of a stream only closes its stream. Safe to call from any go routine or handler, only the first call has an effect.
 func (c *Client) Close(code int, reason string) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	c.close(code, reason, "closed")
+}
 
-	c.hub.unregister <- c
-	for _, room := range c.rooms {
-		c.LeaveRoom(room)
+// Closes the client like Close on behalf of an operator, e.g. through the admin API. The session end is
+// reported as kicked in the logs and metrics.
+func (c *Client) Kick(code int, reason string) {
+	c.close(code, reason, "kicked")
+}
+
+func (c *Client) close(code int, text string, reason string) {
+	if c.parent != nil {
+		code, text = sanitizeClose(code, text)
+		c.parent.closeStream(c, CloseStatus{Code: code, Reason: text, Initiator: InitiatorServer})
+		return
+	}
+
+	c.terminate(code, text, reason)
+}
+
+// Runs once the session of the client ended.
+func (c *Client) onDisconnect() {
+	c.closeStreams()
+	c.cancelRequests()
+	c.protect("disconnect", func() {
+		for _, handler := range c.handlers.disconnectHandlers.take() {
+			handler(c.CloseReason())
+		}
+	})
+	c.leaveRooms()
+}
+
+func (c *Client) leaveRooms() {
+	c.mu.Lock()
+	rooms := c.rooms
+	c.rooms = make([]*Room, 0)
+	c.mu.Unlock()
+
+	for _, room := range rooms {
+		room.removeClient(c)
 	}
-	c.Send(code, []byte(reason))
-	c.conn.Close()
+}
+
+// Ends the session of the client. The queued messages are flushed before the close frame is sent.
+// Only the first call has an effect.
+func (c *Client) terminate(code int, text string, reason string) {
+	code, text = sanitizeClose(code, text)
+	c.mu.Lock()
+	if c.closeFrame != nil {
+		c.mu.Unlock()
+		return
+	}
+	c.closeFrame = websocket.FormatCloseMessage(code, text)
+	c.endReason = reason
+	c.mu.Unlock()
+	c.setCloseStatus(CloseStatus{Code: code, Reason: text, Initiator: InitiatorServer})
+
+	// The hub go routine itself may end up here, e.g. through a broadcast hitting a slow consumer.
+	go func() {
+		select {
+		case c.hub.unregister <- &UnregisterClient{client: c, reason: reason}:
+		case <-c.hub.done:
+		}
+	}()
 }
 
 // Joins the specified room.
 func (c *Client) JoinRoom(room *Room) {
+	room.addClient(c)
+	c.logger.Debug("joined room", "room_id", room.id)
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
-
-	room.addClient(c)
 	c.rooms = append(c.rooms, room)
 }
 
 // Leaves the specified room.
 func (c *Client) LeaveRoom(room *Room) {
+	room.removeClient(c)
+	c.logger.Debug("left room", "room_id", room.id)
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
-
-	room.removeClient(c)
 	index := slices.Index(c.rooms, room)
-	c.rooms = slices.Delete(c.rooms, index, index+1)
+	if index >= 0 {
+		c.rooms = slices.Delete(c.rooms, index, index+1)
+	}
 }
 
 // Returns the remote adress of the client.
 func (c *Client) RemoteAddr() net.Addr {
+	if c.parent != nil {
+		return c.parent.RemoteAddr()
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.conn.RemoteAddr()
 }
 
+// Returns the subprotocol negotiated for the connection, empty if none was.
+func (c *Client) Subprotocol() string {
+	if c.parent != nil {
+		return c.parent.Subprotocol()
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.conn.Subprotocol()
+}
+
 // Returns the context attached to the client.
 func (c *Client) Context() context.Context {
 	c.mu.RLock()
@@ -179,61 +405,73 @@ func (c *Client) SetContext(ctx context.Context) {
 }
 
 // Triggered when client sends a text message
-func (c *Client) HandleText(fun func(a string)) {
-	c.handlers.textHandlers = append(c.handlers.textHandlers, fun)
+func (c *Client) HandleText(fun func(a string)) func() {
+	return c.handlers.textHandlers.add(fun, false)
 }
 
 // Triggered when client sends a text message (which does not match any special message type)
-func (c *Client) HandleBinary(fun func(p []byte)) {
-	c.handlers.binaryHandlers = append(c.handlers.binaryHandlers, fun)
+func (c *Client) HandleBinary(fun func(p []byte)) func() {
+	return c.handlers.binaryHandlers.add(fun, false)
 }
 
 // Triggerd when the client sends a close message. The close gets executed automatically.
-func (c *Client) HandleClose(fun func(p []byte)) {
-	c.handlers.closeHandlers = append(c.handlers.closeHandlers, fun)
+func (c *Client) HandleClose(fun func(p []byte)) func() {
+	return c.handlers.closeHandlers.add(fun, false)
 }
 
 // Triggerd when the client sends a ping message. Pong message will be send automatically.
-func (c *Client) HandlePing(fun func(p []byte)) {
-	c.handlers.pingHandlers = append(c.handlers.pingHandlers, fun)
+func (c *Client) HandlePing(fun func(p []byte)) func() {
+	return c.handlers.pingHandlers.add(fun, false)
 }
 
 // Triggerd when the client sends a pong message.
-func (c *Client) HandlePong(fun func(p []byte)) {
-	c.handlers.pongHandlers = append(c.handlers.pongHandlers, fun)
+func (c *Client) HandlePong(fun func(p []byte)) func() {
+	return c.handlers.pongHandlers.add(fun, false)
 }
 
 // Triggerd when the client sends a broadcast message. If there are no handlers registered the message gets broadcasted.
-func (c *Client) HandleBroadcast(fun func(p []byte)) {
-	c.handlers.broadcastHandlers = append(c.handlers.pongHandlers, fun)
+func (c *Client) HandleBroadcast(fun func(p []byte)) func() {
+	return c.handlers.broadcastHandlers.add(fun, false)
 }
 
-// Triggerd when the client sends a message to a room. If there are no handlers registered the message gets broadcasted to the room.
-func (c *Client) HandleRoomMessage(fun func(roomId string, message []byte)) {
-	c.handlers.roomMessageHandlers = append(c.handlers.roomMessageHandlers, fun)
+// Triggerd when the client sends a message to a room. If there are no handlers registered the message gets broadcasted to the room if the room policy allows it.
+func (c *Client) HandleRoomMessage(fun func(roomId string, message []byte)) func() {
+	return c.handlers.roomMessageHandlers.add(fun, false)
 }
 
-// Triggerd when the client sends a JoinRoom message. If there are no handlers registered the client joins automatically.
-func (c *Client) HandleJoin(fun func(roomId string, rest []byte)) {
-	c.handlers.joinHandlers = append(c.handlers.joinHandlers, fun)
+// Triggerd when the client sends a JoinRoom message. If there are no handlers registered the client joins if the room policy allows it. The rest of the message is the password.
+func (c *Client) HandleJoin(fun func(roomId string, rest []byte)) func() {
+	return c.handlers.joinHandlers.add(fun, false)
 }
 
 // Triggerd when the client sends a LeaveRoom message. If there are no handlers registered the client leaves automatically.
-func (c *Client) HandleLeave(fun func(roomId string, rest []byte)) {
-	c.handlers.leaveHandlers = append(c.handlers.leaveHandlers, fun)
+func (c *Client) HandleLeave(fun func(roomId string, rest []byte)) func() {
+	return c.handlers.leaveHandlers.add(fun, false)
+}
+
+// Triggerd when the client sends a OpenRoom message. If there are no handlers registered the room gets created with the room policy of the namespace, owned by the client.
+func (c *Client) HandleOpenRoom(fun func(joinAfterwards bool, rest []byte)) func() {
+	return c.handlers.openRoomHandlers.add(fun, false)
+}
+
+// Triggerd when the client sends a CloseRoom message. If there are no handlers registered the room gets closed if the room policy allows it.
+func (c *Client) HandleCloseRoom(fun func(roomId string, rest []byte)) func() {
+	return c.handlers.closeRoomHandlers.add(fun, false)
 }
 
-// Triggerd when the client sends a OpenRoom message. If there are no handlers registered the room gets created automatically.
-func (c *Client) HandleOpenRoom(fun func(joinAfterwards bool, rest []byte)) {
-	c.handlers.openRoomHandlers = append(c.handlers.openRoomHandlers, fun)
+// Triggerd when the client sends a Kick message. If there are no handlers registered the client gets kicked if the room policy allows it.
+func (c *Client) HandleKick(fun func(roomId string, clientId string)) func() {
+	return c.handlers.kickHandlers.add(fun, false)
 }
 
-// Triggerd when the client sends a CloseRoom message. If there are no handlers registered the room gets closed automatically.
-func (c *Client) HandleCloseRoom(fun func(roomId string, rest []byte)) {
-	c.handlers.closeRoomHandlers = append(c.handlers.closeRoomHandlers, fun)
+// Triggerd when the client disconnects, with the close code and reason and which side closed. With session recovery
+// enabled a lost connection only counts once the grace period ran out, while a close frame of the client ends the session at once.
+// Replaces the previous handler.
+func (c *Client) HandleDisconnect(fun func(status CloseStatus)) func() {
+	return c.handlers.disconnectHandlers.set(fun)
 }
 
-// Triggerd when the client disconnects
-func (c *Client) HandleDisconnect(fun func()) {
-	c.handlers.disconnectHandler = fun
+// Triggerd when the client reconnects with its resume token and continues its session. Replaces the previous handler.
+func (c *Client) HandleResume(fun func()) func() {
+	return c.handlers.resumeHandlers.set(fun)
 }
@@ -0,0 +1,504 @@
+// Package client implements the client side of the Axion protocol.
+package client
+
+import (
+	"axion"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+var ErrNotConnected = errors.New("axion client: not connected")
+
+// Passed to the disconnect handler when the server sent a frame the client can not read. The connection
+// is closed with 1002 (protocol error).
+var ErrProtocol = errors.New("axion client: protocol error")
+
+type Handlers struct {
+	textHandlers          []func(a string)
+	binaryHandlers        []func(p []byte)
+	clientJoinedHandlers  []func(roomId string, clientId string)
+	clientLeftHandlers    []func(roomId string, clientId string)
+	roomAbandonedHandlers []func(roomId string)
+	clientErrorHandlers   []func(message string)
+	serverErrorHandlers   []func(message string)
+	accessDeniedHandlers  []func(action axion.RoomAction, roomId string, reason string)
+	eventHandlers         map[string]func(p []byte)
+	disconnectHandler     func(err error)
+	stateHandler          func(state State)
+}
+
+type options struct {
+	header        http.Header
+	dialer        *websocket.Dialer
+	reconnect     *ReconnectOptions
+	versions      []int
+	capabilities  axion.Capabilities
+	codec         axion.Codec
+	eventEncoding axion.Encoding
+}
+
+// Configures optional behaviour of a client.
+type Option func(o *options)
+
+// Sets the http header sent with the upgrade request.
+func WithHeader(header http.Header) Option {
+	return func(o *options) {
+		o.header = header
+	}
+}
+
+// Sets the dialer used to connect. Defaults to websocket.DefaultDialer.
+func WithDialer(dialer *websocket.Dialer) Option {
+	return func(o *options) {
+		o.dialer = dialer
+	}
+}
+
+type Client struct {
+	url          string
+	options      *options
+	handlers     *Handlers
+	conn         *websocket.Conn
+	id           string
+	resumeToken  string
+	version      int
+	capabilities axion.Capabilities
+	codec        axion.Codec
+	state        State
+	rooms        map[string]string
+	passwords    map[string]string
+	outbound     []outboundMessage
+	closed       chan struct{}
+	writeMu      sync.Mutex
+	mu           sync.RWMutex
+}
+
+// Creates a new client for the Axion server at url (ws:// or wss://). Register handlers before calling Connect.
+func New(url string, opts ...Option) *Client {
+	c := &Client{
+		url:      url,
+		handlers: new(Handlers),
+		state:    StateClosed,
+		version:  axion.ProtocolV1,
+		codec:    axion.BinaryCodec(axion.ProtocolV1),
+		options: &options{
+			dialer:        websocket.DefaultDialer,
+			versions:      []int{axion.ProtocolV1, axion.ProtocolV2},
+			eventEncoding: axion.JSONEncoding{},
+		},
+		rooms:     make(map[string]string),
+		passwords: make(map[string]string),
+		closed:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c.options)
+	}
+	c.handlers.disconnectHandler = func(err error) {}
+	c.handlers.stateHandler = func(state State) {}
+	c.handlers.eventHandlers = make(map[string]func(p []byte))
+	return c
+}
+
+// Dials the server and waits for the Init message. Incoming messages are dispatched to the handlers
+// from a separate go routine until the connection is closed.
+func (c *Client) Connect(ctx context.Context) error {
+	c.setState(StateConnecting)
+	if err := c.dial(ctx); err != nil {
+		c.setState(StateClosed)
+		return err
+	}
+	return nil
+}
+
+// Dials the server, presenting the resume token of the previous connection if there is one.
+// Rejoins the rooms of the previous session if the server did not resume it and flushes the outbound queue.
+func (c *Client) dial(ctx context.Context) error {
+	target, err := url.Parse(c.url)
+	if err != nil {
+		return err
+	}
+	c.mu.RLock()
+	previousId, resumeToken := c.id, c.resumeToken
+	c.mu.RUnlock()
+	if resumeToken != "" {
+		query := target.Query()
+		query.Set(axion.ResumeTokenParam, resumeToken)
+		target.RawQuery = query.Encode()
+	}
+
+	conn, _, err := c.options.dialer.DialContext(ctx, target.String(), c.requestHeader())
+	if err != nil {
+		return err
+	}
+	codec := axion.BinaryCodec(axion.ProtocolV1)
+	if c.options.codec != nil && conn.Subprotocol() == c.options.codec.Name() {
+		codec = c.options.codec
+	}
+	init, err := readInit(conn, codec)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	version, capabilities := c.choose(init)
+	if err := c.writeStatus(conn, codec, version, capabilities); err != nil {
+		conn.Close()
+		return err
+	}
+	if codec.Name() == "" {
+		codec = axion.BinaryCodec(version)
+	}
+
+	c.mu.Lock()
+	select {
+	case <-c.closed:
+		c.mu.Unlock()
+		conn.Close()
+		return ErrNotConnected
+	default:
+	}
+	c.conn = conn
+	c.id = init.id
+	c.resumeToken = init.resumeToken
+	c.version = version
+	c.capabilities = capabilities
+	c.codec = codec
+	rejoin := make(map[string]string)
+	if init.id != previousId {
+		for roomId, password := range c.rooms {
+			rejoin[roomId] = password
+			c.passwords[roomId] = password
+		}
+		clear(c.rooms)
+	}
+	c.mu.Unlock()
+
+	go c.readPump(conn, codec)
+
+	// Write errors are left to the read pump, which notices the broken connection as well.
+	for roomId, password := range rejoin {
+		p, err := codec.Encode(axion.Frame{Type: axion.JoinRoomMessage, RoomId: roomId, Payload: []byte(password)})
+		if err != nil {
+			continue
+		}
+		if err := c.writeConn(conn, codec.MessageType(), p); err != nil {
+			return nil
+		}
+	}
+	c.flush(conn)
+	return nil
+}
+
+func (c *Client) readPump(conn *websocket.Conn, codec axion.Codec) {
+	// Binary frames the server wrote before it read the Status message are still in version 1.
+	var previous axion.Codec
+	if legacy := axion.BinaryCodec(axion.ProtocolV1); codec.Name() == "" && codec != legacy {
+		previous = legacy
+	}
+	var err error
+	for {
+		var msgType int
+		var p []byte
+		msgType, p, err = conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		if msgType != codec.MessageType() {
+			c.dispatch(msgType, p)
+			continue
+		}
+		frame, decodeErr := codec.Decode(p)
+		if decodeErr == nil {
+			previous = nil
+		} else if previous != nil {
+			frame, decodeErr = previous.Decode(p)
+		}
+		if decodeErr != nil {
+			c.dispatch(msgType, p)
+			continue
+		}
+		if err = c.readFrame(frame, msgType, p); err != nil {
+			c.writeMu.Lock()
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseProtocolError, ""))
+			c.writeMu.Unlock()
+			break
+		}
+	}
+
+	c.mu.Lock()
+	current := c.conn == conn
+	if current {
+		c.conn = nil
+	}
+	c.mu.Unlock()
+	conn.Close()
+
+	c.handlers.disconnectHandler(err)
+	if current {
+		c.connectionLost()
+	}
+}
+
+// Dispatches a protocol frame, p is the message carrying it. Returns an ErrProtocol for frames which can not be read.
+func (c *Client) readFrame(frame axion.Frame, msgType int, p []byte) error {
+	roomId, clientId, rest := frame.RoomId, frame.ClientId, frame.Payload
+
+	switch frame.Type {
+	case axion.SigClientJoined:
+		c.trackRoom(roomId, clientId, true)
+		for _, handler := range c.handlers.clientJoinedHandlers {
+			handler(roomId, clientId)
+		}
+	case axion.SigClientLeft:
+		c.trackRoom(roomId, clientId, false)
+		for _, handler := range c.handlers.clientLeftHandlers {
+			handler(roomId, clientId)
+		}
+	case axion.SigRoomAbandoned:
+		c.trackRoom(roomId, c.Id(), false)
+		for _, handler := range c.handlers.roomAbandonedHandlers {
+			handler(roomId)
+		}
+	case axion.SigClientError:
+		for _, handler := range c.handlers.clientErrorHandlers {
+			handler(string(rest))
+		}
+	case axion.SigAccessDenied:
+		if len(rest) < 1 {
+			return fmt.Errorf("%w: short access denied frame", ErrProtocol)
+		}
+		for _, handler := range c.handlers.accessDeniedHandlers {
+			handler(axion.RoomAction(rest[0]), roomId, string(rest[1:]))
+		}
+	case axion.SigServerError:
+		for _, handler := range c.handlers.serverErrorHandlers {
+			handler(string(rest))
+		}
+	case axion.EventMessage:
+		c.readEvent(frame.Event, rest)
+	default:
+		c.dispatch(msgType, p)
+	}
+	return nil
+}
+
+// Hands application data to the text or binary handlers.
+func (c *Client) dispatch(msgType int, p []byte) {
+	switch msgType {
+	case websocket.TextMessage:
+		for _, handler := range c.handlers.textHandlers {
+			handler(string(p))
+		}
+	case websocket.BinaryMessage:
+		for _, handler := range c.handlers.binaryHandlers {
+			handler(p)
+		}
+	}
+}
+
+func (c *Client) write(msgType int, p []byte) error {
+	return c.send(outboundMessage{msgType: msgType, p: p})
+}
+
+// Writes the message, or queues it while the client is reconnecting.
+func (c *Client) send(message outboundMessage) error {
+	c.mu.Lock()
+	if c.state != StateConnected {
+		defer c.mu.Unlock()
+		return c.enqueue(message)
+	}
+	conn, codec := c.conn, c.codec
+	c.mu.Unlock()
+
+	msgType, p, err := message.encode(codec)
+	if err != nil {
+		return err
+	}
+	return c.writeConn(conn, msgType, p)
+}
+
+func (c *Client) writeConn(conn *websocket.Conn, msgType int, p []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return conn.WriteMessage(msgType, p)
+}
+
+func (c *Client) writeFrame(frame axion.Frame) error {
+	return c.send(outboundMessage{msgType: websocket.BinaryMessage, frame: &frame})
+}
+
+// Keeps track of the rooms the client is in and the passwords it joined them with, so they can be rejoined
+// after a reconnect.
+func (c *Client) trackRoom(roomId string, clientId string, joined bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if clientId != c.id {
+		return
+	}
+	if joined {
+		c.rooms[roomId] = c.passwords[roomId]
+	} else {
+		delete(c.rooms, roomId)
+	}
+	delete(c.passwords, roomId)
+}
+
+// Returns the id the server assigned to the client.
+func (c *Client) Id() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.id
+}
+
+// Returns the token to resume the session. Empty if the server has session recovery disabled.
+func (c *Client) ResumeToken() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.resumeToken
+}
+
+// Sends a text message.
+func (c *Client) SendText(a string) error {
+	return c.write(websocket.TextMessage, []byte(a))
+}
+
+// Sends a binary message. The message should not start with one of the protocol message types.
+func (c *Client) SendBinary(p []byte) error {
+	return c.write(websocket.BinaryMessage, p)
+}
+
+// Asks the server to broadcast the message to all clients.
+func (c *Client) Broadcast(p []byte) error {
+	return c.writeFrame(axion.Frame{Type: axion.BroadCastMessage, Payload: p})
+}
+
+// Sends a message to all members of the room.
+func (c *Client) SendToRoom(roomId string, p []byte) error {
+	return c.writeFrame(axion.Frame{Type: axion.RoomMessage, RoomId: roomId, Payload: p})
+}
+
+// Joins the room. The server confirms with a ClientJoined message.
+func (c *Client) JoinRoom(roomId string) error {
+	return c.JoinRoomWithPassword(roomId, "")
+}
+
+// Joins a room protected by a password. The password is kept to rejoin the room after a reconnect.
+func (c *Client) JoinRoomWithPassword(roomId string, password string) error {
+	c.mu.Lock()
+	c.passwords[roomId] = password
+	c.mu.Unlock()
+	return c.writeFrame(axion.Frame{Type: axion.JoinRoomMessage, RoomId: roomId, Payload: []byte(password)})
+}
+
+// Leaves the room. The server confirms with a ClientLeft message.
+func (c *Client) LeaveRoom(roomId string) error {
+	return c.writeFrame(axion.Frame{Type: axion.LeaveRoomMessage, RoomId: roomId})
+}
+
+// Asks the server to open a new room. If join is set the client joins it and learns the room id from the ClientJoined message.
+func (c *Client) OpenRoom(join bool) error {
+	flag := []byte{0}
+	if join {
+		flag[0] = 1
+	}
+	return c.writeFrame(axion.Frame{Type: axion.OpenRoomMessage, Payload: flag})
+}
+
+// Closes the room. Its members receive a RoomAbandoned message.
+func (c *Client) CloseRoom(roomId string) error {
+	return c.writeFrame(axion.Frame{Type: axion.CloseRoomMessage, RoomId: roomId})
+}
+
+// Removes another client from the room. Only allowed for the owner of the room or roles granted by its policy.
+func (c *Client) Kick(roomId string, clientId string) error {
+	return c.writeFrame(axion.Frame{Type: axion.KickMessage, RoomId: roomId, ClientId: clientId})
+}
+
+// Returns the ids of the rooms the client is in.
+func (c *Client) Rooms() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rooms := make([]string, 0, len(c.rooms))
+	for roomId := range c.rooms {
+		rooms = append(rooms, roomId)
+	}
+	return rooms
+}
+
+// Sends a close frame and closes the connection. Stops reconnecting, the client can not be connected again.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	conn := c.conn
+	c.conn = nil
+	select {
+	case <-c.closed:
+	default:
+		close(c.closed)
+	}
+	c.outbound = nil
+	c.mu.Unlock()
+	c.setState(StateClosed)
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	c.writeMu.Lock()
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	c.writeMu.Unlock()
+	return conn.Close()
+}
+
+// Triggered when the server sends a text message.
+func (c *Client) HandleText(fun func(a string)) {
+	c.handlers.textHandlers = append(c.handlers.textHandlers, fun)
+}
+
+// Triggered when the server sends a binary message which is not a signal, e.g. room messages and broadcasts.
+func (c *Client) HandleBinary(fun func(p []byte)) {
+	c.handlers.binaryHandlers = append(c.handlers.binaryHandlers, fun)
+}
+
+// Triggered when a client (possibly this one) joins a room the client is in.
+func (c *Client) HandleClientJoined(fun func(roomId string, clientId string)) {
+	c.handlers.clientJoinedHandlers = append(c.handlers.clientJoinedHandlers, fun)
+}
+
+// Triggered when a client leaves a room the client is in.
+func (c *Client) HandleClientLeft(fun func(roomId string, clientId string)) {
+	c.handlers.clientLeftHandlers = append(c.handlers.clientLeftHandlers, fun)
+}
+
+// Triggered when a room the client is in gets closed.
+func (c *Client) HandleRoomAbandoned(fun func(roomId string)) {
+	c.handlers.roomAbandonedHandlers = append(c.handlers.roomAbandonedHandlers, fun)
+}
+
+// Triggered when the server rejects a message of the client.
+func (c *Client) HandleClientError(fun func(message string)) {
+	c.handlers.clientErrorHandlers = append(c.handlers.clientErrorHandlers, fun)
+}
+
+// Triggered when a room policy denies an action of the client. roomId is empty for ActionOpen.
+func (c *Client) HandleAccessDenied(fun func(action axion.RoomAction, roomId string, reason string)) {
+	c.handlers.accessDeniedHandlers = append(c.handlers.accessDeniedHandlers, fun)
+}
+
+// Triggered when the server reports an internal error.
+func (c *Client) HandleServerError(fun func(message string)) {
+	c.handlers.serverErrorHandlers = append(c.handlers.serverErrorHandlers, fun)
+}
+
+// Triggered when the connection is lost or closed. err is the error which ended the read loop.
+func (c *Client) HandleDisconnect(fun func(err error)) {
+	c.handlers.disconnectHandler = fun
+}
+
+// Triggered when the connection state of the client changes.
+func (c *Client) HandleStateChange(fun func(state State)) {
+	c.handlers.stateHandler = fun
+}
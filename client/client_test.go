@@ -0,0 +1,191 @@
+package client
+
+import (
+	"axion"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Waits for a value on ch or fails the test.
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out")
+		panic("unreachable")
+	}
+}
+
+func TestClient(t *testing.T) {
+	s := axion.NewServer()
+	s.HandleConnect(func(client *axion.Client, r *http.Request) {
+		client.HandleText(func(message string) {
+			client.SendMessage(axion.NewMessage(1, []byte("echo: "+message)))
+		})
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	connect := func() *Client {
+		c := New(url)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := c.Connect(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	c := connect()
+	texts := make(chan string, 1)
+	c.HandleText(func(a string) { texts <- a })
+	binaries := make(chan string, 1)
+	c.HandleBinary(func(p []byte) { binaries <- string(p) })
+	disconnected := make(chan error, 1)
+	c.HandleDisconnect(func(err error) { disconnected <- err })
+
+	if _, ok := s.GetClientById(c.Id()); !ok {
+		t.Errorf("client %q not registered on the server", c.Id())
+	}
+
+	c.SendText("hi")
+	if text := receive(t, texts); text != "echo: hi" {
+		t.Errorf("got %q", text)
+	}
+
+	other := connect()
+	defer other.Close()
+	other.Broadcast([]byte("news"))
+	if message := receive(t, binaries); message != "news" {
+		t.Errorf("broadcast %q", message)
+	}
+
+	room := s.CreateRoom()
+	joined := make(chan [2]string, 1)
+	c.HandleClientJoined(func(roomId string, clientId string) { joined <- [2]string{roomId, clientId} })
+	left := make(chan [2]string, 1)
+	c.HandleClientLeft(func(roomId string, clientId string) { left <- [2]string{roomId, clientId} })
+	c.JoinRoom(room.Id())
+	if event := receive(t, joined); event != [2]string{room.Id(), c.Id()} {
+		t.Errorf("joined %v", event)
+	}
+	if rooms := c.Rooms(); len(rooms) != 1 || rooms[0] != room.Id() {
+		t.Errorf("client tracks rooms %v", rooms)
+	}
+	c.LeaveRoom(room.Id())
+	if event := receive(t, left); event != [2]string{room.Id(), c.Id()} {
+		t.Errorf("left %v", event)
+	}
+	if rooms := c.Rooms(); len(rooms) != 0 {
+		t.Errorf("client still tracks rooms %v", rooms)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Error(err)
+	}
+	receive(t, disconnected)
+	if err := c.SendText("gone"); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("send after close: got %v", err)
+	}
+	for deadline := time.Now().Add(2 * time.Second); len(s.Clients()) != 1; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("closed client still registered on the server")
+		}
+	}
+}
+
+// Decodes the frames of a fake server without validating them, as a custom codec might.
+type stubCodec struct {
+	frames map[string]axion.Frame
+}
+
+func (stubCodec) Name() string     { return "stub" }
+func (stubCodec) MessageType() int { return websocket.TextMessage }
+
+func (stubCodec) Encode(f axion.Frame) ([]byte, error) {
+	return []byte("frame"), nil
+}
+
+func (s stubCodec) Decode(p []byte) (axion.Frame, error) {
+	if f, ok := s.frames[string(p)]; ok {
+		return f, nil
+	}
+	return axion.Frame{}, axion.ErrUnknownFrame
+}
+
+// Serves a fake server writing the messages after the upgrade and reports the close code it receives.
+func serveStub(t *testing.T, messages ...string) (string, <-chan int) {
+	codes := make(chan int, 1)
+	upgrader := websocket.Upgrader{Subprotocols: []string{"stub"}}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for _, message := range messages {
+			conn.WriteMessage(websocket.TextMessage, []byte(message))
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				var closeErr *websocket.CloseError
+				if errors.As(err, &closeErr) {
+					codes <- closeErr.Code
+				}
+				close(codes)
+				return
+			}
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return "ws" + strings.TrimPrefix(ts.URL, "http"), codes
+}
+
+func TestShortAccessDenied(t *testing.T) {
+	codec := stubCodec{frames: map[string]axion.Frame{
+		"init":   {Type: axion.SigInit, ClientId: "id", Payload: []byte{0, 0, 0, 0, 1, axion.ProtocolV1}},
+		"denied": {Type: axion.SigAccessDenied, RoomId: "lobby"},
+	}}
+	url, codes := serveStub(t, "init", "denied")
+
+	c := New(url, WithCodec(codec))
+	disconnected := make(chan error, 1)
+	c.HandleDisconnect(func(err error) { disconnected <- err })
+	c.HandleAccessDenied(func(action axion.RoomAction, roomId string, reason string) {
+		t.Error("short frame dispatched")
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-disconnected; !errors.Is(err, ErrProtocol) {
+		t.Errorf("got %v, want ErrProtocol", err)
+	}
+	if code := <-codes; code != websocket.CloseProtocolError {
+		t.Errorf("server got close %d, want %d", code, websocket.CloseProtocolError)
+	}
+}
+
+func TestShortInit(t *testing.T) {
+	codec := stubCodec{frames: map[string]axion.Frame{
+		"init": {Type: axion.SigInit, ClientId: "id", Payload: []byte{0, 0}},
+	}}
+	url, _ := serveStub(t, "init")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := New(url, WithCodec(codec)).Connect(ctx); err == nil {
+		t.Error("short init accepted")
+	}
+}
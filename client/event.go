@@ -0,0 +1,42 @@
+package client
+
+import "axion"
+
+// Sets the encoding of event values. Has to match the encoding of the server, defaults to axion.JSONEncoding.
+func WithEventEncoding(encoding axion.Encoding) Option {
+	return func(o *options) {
+		o.eventEncoding = encoding
+	}
+}
+
+// Handles the named event sent by the server. Values which do not decode into a T are dropped.
+// Registering a handler for the same event again replaces it.
+func On[T any](c *Client, event string, fun func(m T)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers.eventHandlers[event] = func(p []byte) {
+		var m T
+		if err := c.options.eventEncoding.Unmarshal(p, &m); err != nil {
+			return
+		}
+		fun(m)
+	}
+}
+
+// Sends the event to the server. Returns an error if the value can not be encoded.
+func (c *Client) Emit(event string, v any) error {
+	p, err := c.options.eventEncoding.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(axion.Frame{Type: axion.EventMessage, Event: event, Payload: p})
+}
+
+func (c *Client) readEvent(event string, p []byte) {
+	c.mu.RLock()
+	handler, ok := c.handlers.eventHandlers[event]
+	c.mu.RUnlock()
+	if ok {
+		handler(p)
+	}
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"axion"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// Sets the protocol versions the client may choose from. The client speaks the highest version the server
+// announces as well and falls back to version 1. Defaults to all versions.
+func WithProtocolVersions(versions ...int) Option {
+	return func(o *options) {
+		o.versions = versions
+	}
+}
+
+// Sets the capabilities the client requests in addition to the ones it implements itself, e.g. CapStreams
+// if the application handles stream frames. CapRecovery is requested whenever reconnecting is enabled.
+func WithCapabilities(capabilities axion.Capabilities) Option {
+	return func(o *options) {
+		o.capabilities = capabilities
+	}
+}
+
+// Offers the codec as subprotocol. Frames are exchanged with it if the server accepts, otherwise the client
+// falls back to the binary frame format.
+func WithCodec(codec axion.Codec) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+// Returns the header of the upgrade request, offering the codec ahead of the protocols already in the header.
+func (c *Client) requestHeader() http.Header {
+	if c.options.codec == nil {
+		return c.options.header
+	}
+	header := c.options.header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	protocols := []string{c.options.codec.Name()}
+	if offered := header.Get("Sec-WebSocket-Protocol"); offered != "" {
+		protocols = append(protocols, offered)
+	}
+	header.Set("Sec-WebSocket-Protocol", strings.Join(protocols, ", "))
+	return header
+}
+
+// Contents of the Init message the server sends on every connection.
+type initMessage struct {
+	id           string
+	resumeToken  string
+	versions     []int
+	capabilities axion.Capabilities
+}
+
+// Reads the Init message, which is in version 1 unless the connection uses a codec.
+func readInit(conn *websocket.Conn, codec axion.Codec) (initMessage, error) {
+	_, p, err := conn.ReadMessage()
+	if err != nil {
+		return initMessage{}, err
+	}
+	frame, err := codec.Decode(p)
+	if err != nil || frame.Type != axion.SigInit || len(frame.Payload) < 5 || len(frame.Payload) < 5+int(frame.Payload[4]) {
+		return initMessage{}, errors.New("axion client: expected init message")
+	}
+	rest := frame.Payload
+	init := initMessage{
+		id:           frame.ClientId,
+		capabilities: axion.Capabilities(binary.BigEndian.Uint32(rest)),
+	}
+	count := int(rest[4])
+	for _, version := range rest[5 : 5+count] {
+		init.versions = append(init.versions, int(version))
+	}
+	init.resumeToken = string(rest[5+count:])
+	return init, nil
+}
+
+// Returns the highest protocol version both sides speak and the capabilities of the connection.
+func (c *Client) choose(init initMessage) (int, axion.Capabilities) {
+	version := axion.ProtocolV1
+	for _, v := range c.options.versions {
+		if v > version && slices.Contains(init.versions, v) {
+			version = v
+		}
+	}
+	requested := c.options.capabilities
+	if c.options.reconnect != nil {
+		requested |= axion.CapRecovery
+	}
+	return version, requested & init.capabilities
+}
+
+// Answers the Init message with the chosen version and the requested capabilities.
+func (c *Client) writeStatus(conn *websocket.Conn, codec axion.Codec, version int, capabilities axion.Capabilities) error {
+	p := binary.BigEndian.AppendUint32([]byte{byte(version)}, uint32(capabilities))
+	status, err := codec.Encode(axion.Frame{Type: axion.StatusMessage, Payload: p})
+	if err != nil {
+		return err
+	}
+	return c.writeConn(conn, codec.MessageType(), status)
+}
+
+// Returns the protocol version negotiated on the current connection.
+func (c *Client) ProtocolVersion() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.version
+}
+
+// Returns the capabilities negotiated on the current connection.
+func (c *Client) Capabilities() axion.Capabilities {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.capabilities
+}
@@ -0,0 +1,177 @@
+package client
+
+import (
+	"axion"
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var ErrQueueFull = errors.New("axion client: outbound queue full")
+
+// Connection state of a client.
+type State int
+
+const (
+	StateConnecting State = iota
+	StateConnected
+	StateReconnecting
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	default:
+		return "closed"
+	}
+}
+
+type ReconnectOptions struct {
+	// Delay before the first attempt. Doubles with every failed attempt up to MaxDelay.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+	// Gives up after this many failed attempts in a row. Zero retries forever.
+	MaxAttempts int
+	// Maximum number of messages buffered while the client is offline.
+	QueueSize int
+}
+
+// Reconnects with exponential backoff and jitter when the connection is lost. Messages sent while offline are
+// buffered and sent after the reconnect, the rooms the client was in are rejoined unless the server resumed the session.
+func WithReconnect(opts ReconnectOptions) Option {
+	return func(o *options) {
+		if opts.MinDelay <= 0 {
+			opts.MinDelay = 500 * time.Millisecond
+		}
+		if opts.MaxDelay < opts.MinDelay {
+			opts.MaxDelay = 30 * time.Second
+		}
+		o.reconnect = &opts
+	}
+}
+
+type outboundMessage struct {
+	msgType int
+	p       []byte
+	frame   *axion.Frame
+}
+
+// Returns the websocket message type and the bytes of the message. Frames are encoded when they are written,
+// with the codec of the connection.
+func (m outboundMessage) encode(codec axion.Codec) (int, []byte, error) {
+	if m.frame == nil {
+		return m.msgType, m.p, nil
+	}
+	p, err := codec.Encode(*m.frame)
+	return codec.MessageType(), p, err
+}
+
+// Returns the connection state of the client.
+func (c *Client) State() State {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.state
+}
+
+func (c *Client) setState(state State) {
+	c.mu.Lock()
+	if c.state == state {
+		c.mu.Unlock()
+		return
+	}
+	c.state = state
+	c.mu.Unlock()
+
+	c.handlers.stateHandler(state)
+}
+
+// Queues a message while the client is not connected. Must be called with the lock held.
+func (c *Client) enqueue(message outboundMessage) error {
+	if c.options.reconnect == nil || c.state == StateClosed {
+		return ErrNotConnected
+	}
+	if len(c.outbound) >= c.options.reconnect.QueueSize {
+		return ErrQueueFull
+	}
+	c.outbound = append(c.outbound, message)
+	return nil
+}
+
+// Writes the queued messages to the new connection and marks the client as connected once the queue is empty.
+// Messages which could not be written stay queued for the next connection.
+func (c *Client) flush(conn *websocket.Conn) {
+	for {
+		c.mu.Lock()
+		outbound, codec := c.outbound, c.codec
+		c.outbound = nil
+		if len(outbound) == 0 {
+			c.mu.Unlock()
+			c.setState(StateConnected)
+			return
+		}
+		c.mu.Unlock()
+
+		for i, message := range outbound {
+			msgType, p, err := message.encode(codec)
+			if err != nil {
+				continue
+			}
+			if err := c.writeConn(conn, msgType, p); err != nil {
+				c.mu.Lock()
+				c.outbound = append(outbound[i:], c.outbound...)
+				c.mu.Unlock()
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) connectionLost() {
+	select {
+	case <-c.closed:
+		return
+	default:
+	}
+	if c.options.reconnect == nil {
+		c.setState(StateClosed)
+		return
+	}
+	c.setState(StateReconnecting)
+	go c.reconnect()
+}
+
+func (c *Client) reconnect() {
+	opts := c.options.reconnect
+	delay := opts.MinDelay
+
+	for attempt := 1; opts.MaxAttempts == 0 || attempt <= opts.MaxAttempts; attempt++ {
+		jittered := delay/2 + rand.N(delay/2+1)
+		select {
+		case <-time.After(jittered):
+		case <-c.closed:
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), opts.MaxDelay)
+		err := c.dial(ctx)
+		cancel()
+		if err == nil {
+			return
+		}
+		delay = min(delay*2, opts.MaxDelay)
+	}
+
+	c.mu.Lock()
+	c.outbound = nil
+	c.mu.Unlock()
+	c.setState(StateClosed)
+}
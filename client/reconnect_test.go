@@ -0,0 +1,169 @@
+package client
+
+import (
+	"axion"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Waits until the client reports the state.
+func waitState(t *testing.T, states <-chan State, want State) {
+	t.Helper()
+	for {
+		if state := receive(t, states); state == want {
+			return
+		}
+	}
+}
+
+func TestReconnectResume(t *testing.T) {
+	s := axion.NewServer(axion.WithSessionRecovery(time.Minute, 8))
+	connected := make(chan *axion.Client, 2)
+	resumed := make(chan struct{}, 1)
+	s.HandleConnect(func(client *axion.Client, r *http.Request) {
+		client.HandleText(func(message string) {
+			client.SendMessage(axion.NewMessage(1, []byte("echo: "+message)))
+		})
+		client.HandleResume(func() { resumed <- struct{}{} })
+		connected <- client
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c := New("ws"+strings.TrimPrefix(ts.URL, "http"), WithReconnect(ReconnectOptions{MinDelay: 100 * time.Millisecond, QueueSize: 8}))
+	defer c.Close()
+	states := make(chan State, 8)
+	c.HandleStateChange(func(state State) { states <- state })
+	texts := make(chan string, 1)
+	c.HandleText(func(a string) { texts <- a })
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-connected
+	id, token := c.Id(), c.ResumeToken()
+
+	// The connection breaks without a close frame, the server keeps the session.
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+	conn.UnderlyingConn().Close()
+	waitState(t, states, StateReconnecting)
+	if err := c.SendText("queued"); err != nil {
+		t.Errorf("send while reconnecting: %v", err)
+	}
+	waitState(t, states, StateConnected)
+
+	receive(t, resumed)
+	if text := receive(t, texts); text != "echo: queued" {
+		t.Errorf("got %q", text)
+	}
+	if c.Id() != id || c.ResumeToken() == token {
+		t.Errorf("resumed as %s with token %q, want %s with a fresh token", c.Id(), c.ResumeToken(), id)
+	}
+	if len(connected) != 0 {
+		t.Error("server started a new session")
+	}
+}
+
+func TestReconnectNewSession(t *testing.T) {
+	s := axion.NewServer()
+	connected := make(chan *axion.Client, 2)
+	s.HandleConnect(func(client *axion.Client, r *http.Request) {
+		connected <- client
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c := New("ws"+strings.TrimPrefix(ts.URL, "http"), WithReconnect(ReconnectOptions{MinDelay: 10 * time.Millisecond, QueueSize: 8}))
+	defer c.Close()
+	states := make(chan State, 8)
+	c.HandleStateChange(func(state State) { states <- state })
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	first := <-connected
+	waitState(t, states, StateConnected)
+
+	first.Close(4000, "restart")
+	waitState(t, states, StateReconnecting)
+	waitState(t, states, StateConnected)
+	second := receive(t, connected)
+	if c.Id() != second.Id() || c.Id() == first.Id() {
+		t.Errorf("client %s after reconnect, want the new session %s", c.Id(), second.Id())
+	}
+
+	c.Close()
+	waitState(t, states, StateClosed)
+	time.Sleep(50 * time.Millisecond)
+	if len(connected) != 0 {
+		t.Error("client reconnected after Close")
+	}
+}
+
+func TestReconnectRejoinsRooms(t *testing.T) {
+	s := axion.NewServer()
+	s.CreateRoomWithId("lobby")
+	vault, _ := s.CreateRoomWithId("vault")
+	vault.SetPolicy(axion.RoomPolicy{Password: "secret"})
+	connected := make(chan *axion.Client, 2)
+	s.HandleConnect(func(client *axion.Client, r *http.Request) {
+		connected <- client
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c := New("ws"+strings.TrimPrefix(ts.URL, "http"), WithReconnect(ReconnectOptions{MinDelay: 10 * time.Millisecond, QueueSize: 8}))
+	defer c.Close()
+	joined := make(chan string, 8)
+	c.HandleClientJoined(func(roomId string, clientId string) {
+		if clientId == c.Id() {
+			joined <- roomId
+		}
+	})
+	c.HandleAccessDenied(func(action axion.RoomAction, roomId string, reason string) {
+		t.Errorf("%s of %s denied: %s", action, roomId, reason)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	first := <-connected
+
+	c.JoinRoom("lobby")
+	c.JoinRoomWithPassword("vault", "secret")
+	waitJoined := func() []string {
+		var rooms []string
+		for len(rooms) < 2 {
+			select {
+			case roomId := <-joined:
+				rooms = append(rooms, roomId)
+			case <-time.After(2 * time.Second):
+				t.Fatalf("joined only %v", rooms)
+			}
+		}
+		slices.Sort(rooms)
+		return rooms
+	}
+	if rooms := waitJoined(); !slices.Equal(rooms, []string{"lobby", "vault"}) {
+		t.Fatalf("joined %v", rooms)
+	}
+
+	first.Close(4000, "restart")
+	second := <-connected
+	if rooms := waitJoined(); !slices.Equal(rooms, []string{"lobby", "vault"}) {
+		t.Errorf("rejoined %v", rooms)
+	}
+	if n := len(second.Rooms()); n != 2 {
+		t.Errorf("new session is in %d rooms, want 2", n)
+	}
+}
@@ -0,0 +1,108 @@
+package axion
+
+import (
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// The side which ended a session.
+type Initiator int
+
+const (
+	InitiatorServer Initiator = iota
+	InitiatorClient
+)
+
+func (i Initiator) String() string {
+	if i == InitiatorClient {
+		return "client"
+	}
+	return "server"
+}
+
+// Tells how the session of a client ended.
+type CloseStatus struct {
+	// RFC 6455 status code. 1006 (abnormal closure) if the connection ended without close frame.
+	Code      int
+	Reason    string
+	Initiator Initiator
+}
+
+// Returns how the session of the client ended. The code is 0 while the client is connected.
+func (c *Client) CloseReason() CloseStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.closeStatus
+}
+
+// Records how the session ended unless it is already known.
+func (c *Client) setCloseStatus(status CloseStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closeStatus.Code == 0 {
+		c.closeStatus = status
+	}
+}
+
+// Returns a code and reason which may be sent in a close frame. Codes reserved for local use
+// (e.g. 1005 and 1006) become 1000 and the reason is cut to the 123 bytes a close frame can carry.
+func sanitizeClose(code int, reason string) (int, string) {
+	valid := (code >= 1000 && code <= 1003) || (code >= 1007 && code <= 1014) || (code >= 3000 && code <= 4999)
+	if !valid {
+		code = websocket.CloseNormalClosure
+	}
+	for len(reason) > 123 {
+		_, size := utf8.DecodeLastRuneInString(reason)
+		reason = reason[:len(reason)-size]
+	}
+	return code, reason
+}
+
+// Returns how a connection ended from the error which stopped reading it, and the reason counted by the metrics.
+func readCloseStatus(err error) (CloseStatus, string) {
+	var closeErr *websocket.CloseError
+	switch {
+	case errors.As(err, &closeErr):
+		return CloseStatus{Code: closeErr.Code, Reason: closeErr.Text, Initiator: InitiatorClient}, "client_closed"
+	case errors.Is(err, websocket.ErrReadLimit):
+		return CloseStatus{Code: websocket.CloseMessageTooBig, Reason: "message too big", Initiator: InitiatorServer}, "message_too_big"
+	case isTimeout(err):
+		return CloseStatus{Code: websocket.CloseAbnormalClosure, Reason: "peer stopped responding", Initiator: InitiatorServer}, "timeout"
+	}
+	return CloseStatus{Code: websocket.CloseAbnormalClosure, Initiator: InitiatorClient}, "connection_lost"
+}
+
+// Answers close frames of the peer unless the server started the closing handshake itself.
+func (c *Client) handleClose(conn *websocket.Conn) func(code int, text string) error {
+	return func(code int, text string) error {
+		c.protect("close", func() {
+			for _, handler := range c.handlers.closeHandlers.take() {
+				handler(websocket.FormatCloseMessage(code, text))
+			}
+		})
+		if c.closing.Load() {
+			return nil
+		}
+		reply := websocket.FormatCloseMessage(code, "")
+		if code == websocket.CloseNoStatusReceived {
+			reply = websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+		}
+		_ = conn.WriteControl(websocket.CloseMessage, reply, time.Now().Add(c.hub.server.options.writeTimeout))
+		return nil
+	}
+}
+
+// Writes the close frame and gives the peer the write timeout to answer it. The read pump closes the
+// connection once the answer arrives or the time is up.
+func (c *Client) closeConnection(conn *websocket.Conn, closeFrame []byte) {
+	timeout := c.hub.server.options.writeTimeout
+	c.closing.Store(true)
+	if err := conn.WriteControl(websocket.CloseMessage, closeFrame, time.Now().Add(timeout)); err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+}
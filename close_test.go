@@ -0,0 +1,70 @@
+package axion
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCloseReason(t *testing.T) {
+	tests := []struct {
+		name string
+		end  func(conn *websocket.Conn)
+		want CloseStatus
+	}{
+		{
+			"peer close",
+			func(conn *websocket.Conn) {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4001, "bye"))
+			},
+			CloseStatus{Code: 4001, Reason: "bye", Initiator: InitiatorClient},
+		},
+		{
+			"read timeout",
+			// Pings are only answered while the peer reads, so a peer which does not read stops responding.
+			func(conn *websocket.Conn) {},
+			CloseStatus{Code: websocket.CloseAbnormalClosure, Reason: "peer stopped responding", Initiator: InitiatorServer},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewServer(WithKeepalive(20*time.Millisecond, 100*time.Millisecond))
+			clients := make(chan *Client, 1)
+			statuses := make(chan CloseStatus, 1)
+			s.HandleConnect(func(client *Client, r *http.Request) {
+				client.HandleDisconnect(func(status CloseStatus) { statuses <- status })
+				clients <- client
+			})
+			ts := httptest.NewServer(s)
+			defer ts.Close()
+
+			conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.ReadMessage()
+			client := <-clients
+			if status := client.CloseReason(); status.Code != 0 {
+				t.Errorf("connected client has close status %+v", status)
+			}
+
+			test.end(conn)
+			select {
+			case status := <-statuses:
+				if status != test.want {
+					t.Errorf("disconnect handler got %+v, want %+v", status, test.want)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("disconnect handler not called")
+			}
+			if status := client.CloseReason(); status != test.want {
+				t.Errorf("got %+v, want %+v", status, test.want)
+			}
+		})
+	}
+}
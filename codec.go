@@ -0,0 +1,190 @@
+package axion
+
+import (
+	"encoding/json"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// Translates protocol frames to websocket messages and back. A connection uses the codec whose name the client
+// offered as subprotocol, connections without one use the binary frame format of their protocol version.
+type Codec interface {
+	// Subprotocol selecting the codec, e.g. "axion.json".
+	Name() string
+	// Websocket message type carrying the frames. Messages of the other type are application data.
+	MessageType() int
+	Encode(f Frame) ([]byte, error)
+	// Returns ErrUnknownFrame if p is application data rather than a protocol frame.
+	Decode(p []byte) (Frame, error)
+}
+
+// Sets the codecs clients can choose with Sec-WebSocket-Protocol, in order of preference.
+// Defaults to JSONCodec and MessagePackCodec, no codecs leave every connection with the binary frame format.
+func WithCodecs(codecs ...Codec) Option {
+	return func(o *serverOptions) {
+		o.codecs = codecs
+	}
+}
+
+// Returns the codec of the binary frame format in the given protocol version.
+func BinaryCodec(version int) Codec {
+	return binaryCodec{version: version}
+}
+
+type binaryCodec struct {
+	version int
+}
+
+// The binary frame format needs no subprotocol.
+func (c binaryCodec) Name() string {
+	return ""
+}
+
+func (c binaryCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (c binaryCodec) Encode(f Frame) ([]byte, error) {
+	return EncodeFrame(c.version, f)
+}
+
+func (c binaryCodec) Decode(p []byte) (Frame, error) {
+	return DecodeFrame(c.version, p)
+}
+
+// Names of the frame types used by the JSON and MessagePack codecs.
+var frameNames = map[uint32]string{
+	StatusMessage:      "status",
+	BroadCastMessage:   "broadcast",
+	RoomMessage:        "room_message",
+	JoinRoomMessage:    "join_room",
+	LeaveRoomMessage:   "leave_room",
+	OpenRoomMessage:    "open_room",
+	CloseRoomMessage:   "close_room",
+	KickMessage:        "kick",
+	StreamMessage:      "stream",
+	OpenStreamMessage:  "open_stream",
+	CloseStreamMessage: "close_stream",
+	RequestMessage:     "request",
+	ResponseMessage:    "response",
+	EventMessage:       "event",
+
+	SigInit:           "init",
+	SigClientError:    "client_error",
+	SigServerError:    "server_error",
+	SigRoomAbandoned:  "room_abandoned",
+	SigClientLeft:     "client_left",
+	SigClientJoined:   "client_joined",
+	SigAccessDenied:   "access_denied",
+	SigStreamOpened:   "stream_opened",
+	SigStreamClosed:   "stream_closed",
+	SigStreamRejected: "stream_rejected",
+}
+
+var frameTypes = func() map[string]uint32 {
+	types := make(map[string]uint32, len(frameNames))
+	for kind, name := range frameNames {
+		types[name] = kind
+	}
+	return types
+}()
+
+// Carries frames as JSON text messages, subprotocol "axion.json":
+//
+//	{"type": "room_message", "room": "lobby", "data": "hello"}
+//
+// Payloads which are valid UTF-8 are in "data", any other payload is base64 encoded in "binary".
+// Event frames carry the name in "event" and a payload which is JSON itself in "value":
+//
+//	{"type": "event", "event": "chat", "value": {"text": "hello"}}
+//
+// Unused fields are omitted.
+type JSONCodec struct{}
+
+type jsonFrame struct {
+	Type   string          `json:"type"`
+	Room   string          `json:"room,omitempty"`
+	Client string          `json:"client,omitempty"`
+	Event  string          `json:"event,omitempty"`
+	Data   string          `json:"data,omitempty"`
+	Binary []byte          `json:"binary,omitempty"`
+	Value  json.RawMessage `json:"value,omitempty"`
+}
+
+func (JSONCodec) Name() string {
+	return "axion.json"
+}
+
+func (JSONCodec) MessageType() int {
+	return websocket.TextMessage
+}
+
+func (JSONCodec) Encode(f Frame) ([]byte, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	frame := jsonFrame{Type: frameNames[f.Type], Room: f.RoomId, Client: f.ClientId, Event: f.Event}
+	switch {
+	case f.Type == EventMessage && json.Valid(f.Payload):
+		frame.Value = f.Payload
+	case utf8.Valid(f.Payload):
+		frame.Data = string(f.Payload)
+	default:
+		frame.Binary = f.Payload
+	}
+	return json.Marshal(frame)
+}
+
+func (JSONCodec) Decode(p []byte) (Frame, error) {
+	var frame jsonFrame
+	if err := json.Unmarshal(p, &frame); err != nil {
+		return Frame{}, ErrUnknownFrame
+	}
+	kind, ok := frameTypes[frame.Type]
+	if !ok {
+		return Frame{}, ErrUnknownFrame
+	}
+	f := Frame{Type: kind, RoomId: frame.Room, ClientId: frame.Client, Event: frame.Event, Payload: frame.Binary}
+	switch {
+	case frame.Value != nil:
+		f.Payload = frame.Value
+	case frame.Data != "":
+		f.Payload = []byte(frame.Data)
+	}
+	if err := f.validate(); err != nil {
+		return Frame{}, err
+	}
+	return f, nil
+}
+
+// Returns the configured codec named by the subprotocol, nil for the binary frame format.
+func (s *Server) codecFor(subprotocol string) Codec {
+	for _, codec := range s.options.codecs {
+		if codec.Name() == subprotocol && subprotocol != "" {
+			return codec
+		}
+	}
+	return nil
+}
+
+// Returns the codec frames are exchanged with on the connection of the client.
+func (c *Client) Codec() Codec {
+	if codec := c.Connection().codec.Load(); codec != nil {
+		return *codec
+	}
+	return BinaryCodec(c.ProtocolVersion())
+}
+
+// Returns the type of the protocol frame in p, without dispatching it.
+func (c *Client) peekFrameType(msgType int, p []byte) (uint32, bool) {
+	codec := c.Codec()
+	if msgType != codec.MessageType() {
+		return 0, false
+	}
+	if binary, ok := codec.(binaryCodec); ok {
+		return PeekFrameType(binary.version, p)
+	}
+	f, err := codec.Decode(p)
+	return f.Type, err == nil
+}
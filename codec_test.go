@@ -0,0 +1,63 @@
+package axion
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	frames := []Frame{
+		{Type: BroadCastMessage, Payload: []byte("hello")},
+		{Type: RoomMessage, RoomId: "lobby", Payload: []byte{0xff, 0x00, 0x01}},
+		{Type: KickMessage, RoomId: "lobby", ClientId: "alice"},
+		{Type: SigAccessDenied, Payload: append([]byte{byte(ActionOpen)}, "denied"...)},
+		{Type: RoomMessage, RoomId: "lobby", Payload: bytes.Repeat([]byte("x"), 70000)},
+	}
+
+	for _, codec := range []Codec{JSONCodec{}, MessagePackCodec{}, BinaryCodec(ProtocolV2)} {
+		for _, f := range frames {
+			p, err := codec.Encode(f)
+			if err != nil {
+				t.Fatalf("%T: encoding %#x: %v", codec, f.Type, err)
+			}
+			got, err := codec.Decode(p)
+			if err != nil {
+				t.Fatalf("%T: decoding %#x: %v", codec, f.Type, err)
+			}
+			if got.Type != f.Type || got.RoomId != f.RoomId || got.ClientId != f.ClientId || !bytes.Equal(got.Payload, f.Payload) {
+				t.Errorf("%T: frame %#x did not round trip", codec, f.Type)
+			}
+		}
+	}
+}
+
+func TestCodecDecode(t *testing.T) {
+	// {"type": "join_room", "room": "lobby", "seq": 7} as written by a MessagePack library.
+	msgpack := []byte{0x83, 0xa4, 't', 'y', 'p', 'e', 0xa9, 'j', 'o', 'i', 'n', '_', 'r', 'o', 'o', 'm',
+		0xa4, 'r', 'o', 'o', 'm', 0xa5, 'l', 'o', 'b', 'b', 'y', 0xa3, 's', 'e', 'q', 0x07}
+
+	tests := []struct {
+		codec Codec
+		p     []byte
+		want  error
+	}{
+		{MessagePackCodec{}, msgpack, nil},
+		{MessagePackCodec{}, []byte{0x01, 0x02}, ErrUnknownFrame},
+		{MessagePackCodec{}, msgpack[:20], ErrShortFrame},
+		{JSONCodec{}, []byte(`{"type":"join_room","room":"lobby"}`), nil},
+		{JSONCodec{}, []byte(`{"type":"join_room"}`), ErrInvalidField},
+		{JSONCodec{}, []byte(`{"type":"chat","text":"hi"}`), ErrUnknownFrame},
+		{JSONCodec{}, []byte(`hello`), ErrUnknownFrame},
+	}
+
+	for _, test := range tests {
+		f, err := test.codec.Decode(test.p)
+		if !errors.Is(err, test.want) {
+			t.Errorf("%T %q: got %v, want %v", test.codec, test.p, err, test.want)
+		}
+		if err == nil && (f.Type != JoinRoomMessage || f.RoomId != "lobby") {
+			t.Errorf("%T %q: got %+v", test.codec, test.p, f)
+		}
+	}
+}
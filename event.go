@@ -0,0 +1,138 @@
+package axion
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Marshals the values of events.
+type Encoding interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(p []byte, v any) error
+}
+
+// Encodes event values as JSON.
+type JSONEncoding struct{}
+
+func (JSONEncoding) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONEncoding) Unmarshal(p []byte, v any) error {
+	return json.Unmarshal(p, v)
+}
+
+// Sets the encoding of event values. Defaults to JSONEncoding.
+func WithEventEncoding(encoding Encoding) Option {
+	return func(o *serverOptions) {
+		o.eventEncoding = encoding
+	}
+}
+
+type eventHandler func(client *Client, p []byte) error
+
+// Registers event handlers, see On. Handlers of a client take precedence over the handlers of its namespace,
+// the handlers of the server are the ones of the default namespace.
+type Router interface {
+	route(event string, handler eventHandler) func()
+}
+
+// Handles the named event sent by clients. The value of the event is decoded into a T, values which do not
+// decode are reported to the sender as ClientError. Errors returned by the handler are reported like the
+// errors of request handlers: errors created with NewClientError as ClientError, all others as ServerError.
+// Registering a handler for the same event again replaces it. Returns the function removing the handler.
+func On[T any](r Router, event string, fun func(c *Client, m T) error) func() {
+	return r.route(event, func(c *Client, p []byte) error {
+		var m T
+		if err := c.hub.server.options.eventEncoding.Unmarshal(p, &m); err != nil {
+			return NewClientError(fmt.Sprintf("invalid %s event: %v", event, err))
+		}
+		return fun(c, m)
+	})
+}
+
+func (s *Server) route(event string, handler eventHandler) func() {
+	return s.hub.namespace.route(event, handler)
+}
+
+func (ns *Namespace) route(event string, handler eventHandler) func() {
+	return ns.handlers.eventHandlers.set(event, handler)
+}
+
+func (c *Client) route(event string, handler eventHandler) func() {
+	return c.handlers.eventHandlers.set(event, handler)
+}
+
+// Creates a new Event message carrying the encoded value.
+func NewEventMessage(event string, value []byte) WsMessage {
+	return NewFrameMessage(Frame{Type: EventMessage, Event: event, Payload: value})
+}
+
+func (s *Server) newEventMessage(event string, v any) (WsMessage, error) {
+	p, err := s.options.eventEncoding.Marshal(v)
+	if err != nil {
+		return WsMessage{}, err
+	}
+	message := NewEventMessage(event, p)
+	if err := message.frame.validate(); err != nil {
+		return WsMessage{}, err
+	}
+	return message, nil
+}
+
+// Sends the event to the client. Returns an error if the value can not be encoded.
+func (c *Client) Emit(event string, v any) error {
+	message, err := c.hub.server.newEventMessage(event, v)
+	if err != nil {
+		return err
+	}
+	c.SendMessage(message)
+	return nil
+}
+
+// Sends the event to all members of the room. Returns an error if the value can not be encoded.
+func (r *Room) Emit(event string, v any) error {
+	message, err := r.hub.server.newEventMessage(event, v)
+	if err != nil {
+		return err
+	}
+	r.BroadcastMessage(message)
+	return nil
+}
+
+// Sends the event to all clients of the namespace. Returns an error if the value can not be encoded.
+func (ns *Namespace) Emit(event string, v any) error {
+	message, err := ns.hub.server.newEventMessage(event, v)
+	if err != nil {
+		return err
+	}
+	ns.BroadcastMessage(message)
+	return nil
+}
+
+// Sends the event to all clients of the default namespace. Returns an error if the value can not be encoded.
+func (s *Server) Emit(event string, v any) error {
+	return s.hub.namespace.Emit(event, v)
+}
+
+// Dispatches an event to the handler of the client or of its namespace.
+func (c *Client) readEvent(event string, p []byte) {
+	handler, ok := c.handlers.eventHandlers.get(event)
+	if !ok {
+		handler, ok = c.hub.namespace.handlers.eventHandlers.get(event)
+	}
+	if !ok {
+		c.clientError("unknown event " + event)
+		return
+	}
+	if err := handler(c, p); err != nil {
+		var requestErr *RequestError
+		if errors.As(err, &requestErr) && requestErr.Code == SigClientError {
+			c.clientError(requestErr.Message)
+			return
+		}
+		c.logger.Warn("event handler failed", "event", event, "error", err)
+		c.SendMessage(NewServerErrorMessage(err.Error()))
+	}
+}
@@ -0,0 +1,53 @@
+package axion
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+type chatMessage struct {
+	Text string `json:"text"`
+}
+
+func TestEvents(t *testing.T) {
+	s := NewServer()
+	On(s, "chat", func(c *Client, m chatMessage) error {
+		if m.Text == "" {
+			return NewClientError("empty message")
+		}
+		return c.Emit("chat", chatMessage{Text: "echo " + m.Text})
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	header := map[string][]string{"Sec-WebSocket-Protocol": {"axion.json"}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.ReadMessage()
+
+	tests := []struct {
+		sent string
+		want string
+	}{
+		{`{"type":"event","event":"chat","value":{"text":"hi"}}`, `{"type":"event","event":"chat","value":{"text":"echo hi"}}`},
+		{`{"type":"event","event":"chat","value":{"text":""}}`, `{"type":"client_error","data":"empty message"}`},
+		{`{"type":"event","event":"chat","value":[1,2]}`, `{"type":"client_error","data":"invalid chat event: `},
+		{`{"type":"event","event":"typing"}`, `{"type":"client_error","data":"unknown event typing"}`},
+	}
+	for _, test := range tests {
+		conn.WriteMessage(websocket.TextMessage, []byte(test.sent))
+		_, p, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(p), test.want) {
+			t.Errorf("sent %s: got %s, want %s", test.sent, p, test.want)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package axion
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Protocol versions a frame can be encoded in.
+//
+// Version 1 frames are the message type (4 bytes) followed by the fixed size fields of the type and the payload.
+// Room and client ids are 36 character uuids.
+//
+//	type (4 bytes) | room id (36 bytes) | client id (36 bytes) | payload
+//
+// Version 2 frames start with the version byte and carry every id length prefixed, so ids of any length work.
+// Fields a type does not use are empty.
+//
+//	version (1 byte) | type (4 bytes) | room id length (2 bytes) | room id | client id length (2 bytes) | client id | payload
+//
+// In both versions event frames carry the event name in front of the payload: name length (1 byte) | name.
+const (
+	ProtocolV1 = 1
+	ProtocolV2 = 2
+)
+
+// Length of the uuids used as ids in version 1 frames.
+const idLength = 36
+
+// Version 1 placeholder for a missing optional room id.
+const nilId = "00000000-0000-0000-0000-000000000000"
+
+var (
+	// The message does not start with a known message type. It is application data.
+	ErrUnknownFrame = errors.New("axion: not a protocol frame")
+	// The frame ends before a field it announces.
+	ErrShortFrame = errors.New("axion: frame too short")
+	// A field does not fit into the frame format, e.g. a room id which is not a uuid in version 1.
+	ErrInvalidField = errors.New("axion: invalid frame field")
+	// The frame is encoded in a version the connection does not speak.
+	ErrUnsupportedVersion = errors.New("axion: unsupported protocol version")
+)
+
+// A decoded protocol message or signal. Which fields are set depends on the type.
+type Frame struct {
+	Type     uint32
+	RoomId   string
+	ClientId string
+	Event    string
+	Payload  []byte
+}
+
+type frameLayout struct {
+	room       bool
+	client     bool
+	optional   bool
+	event      bool
+	minPayload int
+}
+
+var frameLayouts = map[uint32]frameLayout{
+	StatusMessage:      {minPayload: 1},
+	BroadCastMessage:   {},
+	RoomMessage:        {room: true},
+	JoinRoomMessage:    {room: true},
+	LeaveRoomMessage:   {room: true},
+	OpenRoomMessage:    {minPayload: 1},
+	CloseRoomMessage:   {room: true},
+	KickMessage:        {room: true, client: true},
+	StreamMessage:      {minPayload: 5},
+	OpenStreamMessage:  {minPayload: 4},
+	CloseStreamMessage: {minPayload: 4},
+	RequestMessage:     {minPayload: 6},
+	ResponseMessage:    {minPayload: 8},
+	EventMessage:       {event: true},
+
+	SigInit:           {client: true, minPayload: 5},
+	SigClientError:    {},
+	SigServerError:    {},
+	SigRoomAbandoned:  {room: true},
+	SigClientLeft:     {room: true, client: true},
+	SigClientJoined:   {room: true, client: true},
+	SigAccessDenied:   {room: true, optional: true, minPayload: 1},
+	SigStreamOpened:   {minPayload: 4},
+	SigStreamClosed:   {minPayload: 6},
+	SigStreamRejected: {minPayload: 4},
+}
+
+// Returns the message type of a frame without decoding it.
+func PeekFrameType(version int, p []byte) (uint32, bool) {
+	if version == ProtocolV2 {
+		if len(p) < 5 || p[0] != ProtocolV2 {
+			return 0, false
+		}
+		p = p[1:]
+	}
+	if len(p) < 4 {
+		return 0, false
+	}
+	kind := binary.BigEndian.Uint32(p)
+	_, ok := frameLayouts[kind]
+	return kind, ok
+}
+
+// Encodes the frame in the given protocol version.
+func EncodeFrame(version int, f Frame) ([]byte, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	layout := frameLayouts[f.Type]
+
+	switch version {
+	case ProtocolV1:
+		roomId := f.RoomId
+		if layout.optional && roomId == "" {
+			roomId = nilId
+		}
+		if layout.room && len(roomId) != idLength || layout.client && len(f.ClientId) != idLength {
+			return nil, fmt.Errorf("%w: version 1 ids have %d bytes", ErrInvalidField, idLength)
+		}
+		p := make([]byte, 0, 4+2*idLength+len(f.Payload))
+		p = binary.BigEndian.AppendUint32(p, f.Type)
+		if layout.room {
+			p = append(p, roomId...)
+		}
+		if layout.client {
+			p = append(p, f.ClientId...)
+		}
+		return append(appendEvent(p, layout, f.Event), f.Payload...), nil
+	case ProtocolV2:
+		if len(f.RoomId) > 0xFFFF || len(f.ClientId) > 0xFFFF {
+			return nil, fmt.Errorf("%w: ids have at most %d bytes", ErrInvalidField, 0xFFFF)
+		}
+		p := make([]byte, 0, 9+len(f.RoomId)+len(f.ClientId)+len(f.Payload))
+		p = append(p, ProtocolV2)
+		p = binary.BigEndian.AppendUint32(p, f.Type)
+		p = binary.BigEndian.AppendUint16(p, uint16(len(f.RoomId)))
+		p = append(p, f.RoomId...)
+		p = binary.BigEndian.AppendUint16(p, uint16(len(f.ClientId)))
+		p = append(p, f.ClientId...)
+		return append(appendEvent(p, layout, f.Event), f.Payload...), nil
+	}
+	return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+}
+
+// Decodes a frame of the given protocol version. Returns ErrUnknownFrame if p is not a protocol frame,
+// the payload of the frame references p.
+func DecodeFrame(version int, p []byte) (Frame, error) {
+	if version != ProtocolV1 && version != ProtocolV2 {
+		return Frame{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+	kind, ok := PeekFrameType(version, p)
+	if !ok {
+		return Frame{}, ErrUnknownFrame
+	}
+	layout := frameLayouts[kind]
+	f := Frame{Type: kind}
+
+	if version == ProtocolV1 {
+		rest := p[4:]
+		if layout.room {
+			if len(rest) < idLength {
+				return Frame{}, fmt.Errorf("%w: room id of %#x", ErrShortFrame, kind)
+			}
+			f.RoomId, rest = string(rest[:idLength]), rest[idLength:]
+			if layout.optional && f.RoomId == nilId {
+				f.RoomId = ""
+			}
+		}
+		if layout.client {
+			if len(rest) < idLength {
+				return Frame{}, fmt.Errorf("%w: client id of %#x", ErrShortFrame, kind)
+			}
+			f.ClientId, rest = string(rest[:idLength]), rest[idLength:]
+		}
+		if f.Event, rest, ok = readEvent(rest, layout); !ok {
+			return Frame{}, fmt.Errorf("%w: event name of %#x", ErrShortFrame, kind)
+		}
+		f.Payload = rest
+	} else {
+		rest := p[5:]
+		var err error
+		if f.RoomId, rest, err = readField(rest); err != nil {
+			return Frame{}, fmt.Errorf("%w: room id of %#x", err, kind)
+		}
+		if f.ClientId, rest, err = readField(rest); err != nil {
+			return Frame{}, fmt.Errorf("%w: client id of %#x", err, kind)
+		}
+		if f.Event, rest, ok = readEvent(rest, layout); !ok {
+			return Frame{}, fmt.Errorf("%w: event name of %#x", ErrShortFrame, kind)
+		}
+		f.Payload = rest
+	}
+
+	if err := f.validate(); err != nil {
+		return Frame{}, err
+	}
+	return f, nil
+}
+
+// Checks that the frame carries the fields its type requires.
+func (f Frame) validate() error {
+	layout, ok := frameLayouts[f.Type]
+	if !ok {
+		return ErrUnknownFrame
+	}
+	if layout.room && !layout.optional && f.RoomId == "" || layout.client && f.ClientId == "" {
+		return fmt.Errorf("%w: missing id of %#x", ErrInvalidField, f.Type)
+	}
+	if layout.event && (f.Event == "" || len(f.Event) > 0xFF) {
+		return fmt.Errorf("%w: event names have 1 to %d bytes", ErrInvalidField, 0xFF)
+	}
+	if len(f.Payload) < layout.minPayload {
+		return fmt.Errorf("%w: payload of %#x", ErrShortFrame, f.Type)
+	}
+	return nil
+}
+
+// Reads a field with a 2 byte length prefix.
+func readField(p []byte) (string, []byte, error) {
+	if len(p) < 2 {
+		return "", nil, ErrShortFrame
+	}
+	length := int(binary.BigEndian.Uint16(p))
+	if len(p) < 2+length {
+		return "", nil, ErrShortFrame
+	}
+	return string(p[2 : 2+length]), p[2+length:], nil
+}
+
+func appendEvent(p []byte, layout frameLayout, event string) []byte {
+	if !layout.event {
+		return p
+	}
+	p = append(p, byte(len(event)))
+	return append(p, event...)
+}
+
+// Reads the event name of an event frame. Reports false if p is too short.
+func readEvent(p []byte, layout frameLayout) (string, []byte, bool) {
+	if !layout.event {
+		return "", p, true
+	}
+	if len(p) < 1 || len(p) < 1+int(p[0]) {
+		return "", nil, false
+	}
+	return string(p[1 : 1+p[0]]), p[1+p[0]:], true
+}
@@ -0,0 +1,77 @@
+package axion
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	roomId := "6f1c2a3e-9d4b-4c1a-8e2f-0a1b2c3d4e5f"
+	clientId := "a0b1c2d3-e4f5-4a6b-8c7d-8e9f0a1b2c3d"
+	frames := []Frame{
+		{Type: BroadCastMessage, Payload: []byte("hello")},
+		{Type: RoomMessage, RoomId: roomId, Payload: []byte("hello")},
+		{Type: JoinRoomMessage, RoomId: roomId},
+		{Type: KickMessage, RoomId: roomId, ClientId: clientId},
+		{Type: SigClientJoined, RoomId: roomId, ClientId: clientId},
+		{Type: SigInit, ClientId: clientId, Payload: []byte("token")},
+		{Type: SigAccessDenied, Payload: []byte{byte(ActionOpen)}},
+		{Type: SigAccessDenied, RoomId: roomId, Payload: append([]byte{byte(ActionJoin)}, "denied"...)},
+	}
+
+	for _, version := range []int{ProtocolV1, ProtocolV2} {
+		for _, f := range frames {
+			p, err := EncodeFrame(version, f)
+			if err != nil {
+				t.Fatalf("v%d: encoding %#x: %v", version, f.Type, err)
+			}
+			got, err := DecodeFrame(version, p)
+			if err != nil {
+				t.Fatalf("v%d: decoding %#x: %v", version, f.Type, err)
+			}
+			if got.Type != f.Type || got.RoomId != f.RoomId || got.ClientId != f.ClientId || !bytes.Equal(got.Payload, f.Payload) {
+				t.Errorf("v%d: got %+v, want %+v", version, got, f)
+			}
+		}
+	}
+
+	// Version 2 ids are not bound to uuids.
+	p, err := EncodeFrame(ProtocolV2, Frame{Type: RoomMessage, RoomId: "lobby", Payload: []byte("hi")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, err := DecodeFrame(ProtocolV2, p); err != nil || f.RoomId != "lobby" {
+		t.Errorf("got %+v, %v", f, err)
+	}
+}
+
+func TestMalformedFrames(t *testing.T) {
+	v1Join, _ := EncodeFrame(ProtocolV1, Frame{Type: JoinRoomMessage, RoomId: "6f1c2a3e-9d4b-4c1a-8e2f-0a1b2c3d4e5f"})
+	v2Join, _ := EncodeFrame(ProtocolV2, Frame{Type: JoinRoomMessage, RoomId: "lobby"})
+
+	tests := []struct {
+		name    string
+		version int
+		p       []byte
+		want    error
+	}{
+		{"application data", ProtocolV1, []byte("hello world"), ErrUnknownFrame},
+		{"short room id", ProtocolV1, v1Join[:20], ErrShortFrame},
+		{"short length prefix", ProtocolV2, v2Join[:6], ErrShortFrame},
+		{"length beyond frame", ProtocolV2, v2Join[:len(v2Join)-1], ErrShortFrame},
+		{"missing room id", ProtocolV2, []byte{ProtocolV2, 0x10, 0x11, 0x43, 0x00, 0, 0, 0, 0}, ErrInvalidField},
+		{"v1 frame as v2", ProtocolV2, v1Join, ErrUnknownFrame},
+		{"unsupported version", 7, v1Join, ErrUnsupportedVersion},
+	}
+
+	for _, test := range tests {
+		if _, err := DecodeFrame(test.version, test.p); !errors.Is(err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+	}
+
+	if _, err := EncodeFrame(ProtocolV1, Frame{Type: RoomMessage, RoomId: "lobby"}); !errors.Is(err, ErrInvalidField) {
+		t.Errorf("v1 room id which is not a uuid: got %v", err)
+	}
+}
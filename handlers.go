@@ -0,0 +1,183 @@
+package axion
+
+import "sync"
+
+type handlerEntry[F any] struct {
+	fun  F
+	once bool
+}
+
+// Handlers of one kind, safe for concurrent registration and dispatch. The slices are replaced on every
+// change, so a dispatch works on the handlers registered when the message arrived.
+type handlerList[F any] struct {
+	mu      sync.Mutex
+	entries []*handlerEntry[F]
+	funs    []F
+	once    int
+}
+
+func (l *handlerList[F]) update(entries []*handlerEntry[F]) {
+	funs := make([]F, len(entries))
+	l.once = 0
+	for i, entry := range entries {
+		funs[i] = entry.fun
+		if entry.once {
+			l.once++
+		}
+	}
+	l.entries, l.funs = entries, funs
+}
+
+// Adds a handler and returns the function removing it again.
+func (l *handlerList[F]) add(fun F, once bool) func() {
+	entry := &handlerEntry[F]{fun: fun, once: once}
+	l.mu.Lock()
+	l.update(append(l.entries[:len(l.entries):len(l.entries)], entry))
+	l.mu.Unlock()
+	return func() { l.remove(entry) }
+}
+
+// Replaces all handlers with fun and returns the function removing it again.
+func (l *handlerList[F]) set(fun F) func() {
+	entry := &handlerEntry[F]{fun: fun}
+	l.mu.Lock()
+	l.update([]*handlerEntry[F]{entry})
+	l.mu.Unlock()
+	return func() { l.remove(entry) }
+}
+
+func (l *handlerList[F]) remove(entry *handlerEntry[F]) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, e := range l.entries {
+		if e == entry {
+			entries := make([]*handlerEntry[F], 0, len(l.entries)-1)
+			l.update(append(append(entries, l.entries[:i]...), l.entries[i+1:]...))
+			return
+		}
+	}
+}
+
+// Returns the handlers to call for a message and removes the one-shot handlers among them.
+func (l *handlerList[F]) take() []F {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	funs := l.funs
+	if l.once > 0 {
+		entries := make([]*handlerEntry[F], 0, len(l.entries)-l.once)
+		for _, entry := range l.entries {
+			if !entry.once {
+				entries = append(entries, entry)
+			}
+		}
+		l.update(entries)
+	}
+	return funs
+}
+
+// Handlers by name, safe for concurrent registration and dispatch.
+type handlerMap[F any] struct {
+	mu      sync.RWMutex
+	entries map[string]*handlerEntry[F]
+}
+
+// Sets the handler for name and returns the function removing it again. Removing has no effect once
+// the handler got replaced.
+func (m *handlerMap[F]) set(name string, fun F) func() {
+	entry := &handlerEntry[F]{fun: fun}
+	m.mu.Lock()
+	if m.entries == nil {
+		m.entries = make(map[string]*handlerEntry[F])
+	}
+	m.entries[name] = entry
+	m.mu.Unlock()
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.entries[name] == entry {
+			delete(m.entries, name)
+		}
+	}
+}
+
+func (m *handlerMap[F]) get(name string) (F, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entry, ok := m.entries[name]
+	if !ok {
+		var zero F
+		return zero, false
+	}
+	return entry.fun, true
+}
+
+// Registers one-shot handlers of a client, see Client.Once.
+type OnceHandlers struct {
+	c *Client
+}
+
+// Returns the registration of one-shot handlers. A one-shot handler runs for the next matching message only
+// and is removed afterwards, like the handlers of Client it counts as registered until then.
+func (c *Client) Once() OnceHandlers {
+	return OnceHandlers{c: c}
+}
+
+// Triggered by the next text message, see Client.HandleText.
+func (o OnceHandlers) HandleText(fun func(a string)) func() {
+	return o.c.handlers.textHandlers.add(fun, true)
+}
+
+// Triggered by the next binary message, see Client.HandleBinary.
+func (o OnceHandlers) HandleBinary(fun func(p []byte)) func() {
+	return o.c.handlers.binaryHandlers.add(fun, true)
+}
+
+// Triggered by the next close message, see Client.HandleClose.
+func (o OnceHandlers) HandleClose(fun func(p []byte)) func() {
+	return o.c.handlers.closeHandlers.add(fun, true)
+}
+
+// Triggered by the next ping message, see Client.HandlePing.
+func (o OnceHandlers) HandlePing(fun func(p []byte)) func() {
+	return o.c.handlers.pingHandlers.add(fun, true)
+}
+
+// Triggered by the next pong message, see Client.HandlePong.
+func (o OnceHandlers) HandlePong(fun func(p []byte)) func() {
+	return o.c.handlers.pongHandlers.add(fun, true)
+}
+
+// Triggered by the next broadcast message, see Client.HandleBroadcast.
+func (o OnceHandlers) HandleBroadcast(fun func(p []byte)) func() {
+	return o.c.handlers.broadcastHandlers.add(fun, true)
+}
+
+// Triggered by the next room message, see Client.HandleRoomMessage.
+func (o OnceHandlers) HandleRoomMessage(fun func(roomId string, message []byte)) func() {
+	return o.c.handlers.roomMessageHandlers.add(fun, true)
+}
+
+// Triggered by the next JoinRoom message, see Client.HandleJoin.
+func (o OnceHandlers) HandleJoin(fun func(roomId string, rest []byte)) func() {
+	return o.c.handlers.joinHandlers.add(fun, true)
+}
+
+// Triggered by the next LeaveRoom message, see Client.HandleLeave.
+func (o OnceHandlers) HandleLeave(fun func(roomId string, rest []byte)) func() {
+	return o.c.handlers.leaveHandlers.add(fun, true)
+}
+
+// Triggered by the next OpenRoom message, see Client.HandleOpenRoom.
+func (o OnceHandlers) HandleOpenRoom(fun func(joinAfterwards bool, rest []byte)) func() {
+	return o.c.handlers.openRoomHandlers.add(fun, true)
+}
+
+// Triggered by the next CloseRoom message, see Client.HandleCloseRoom.
+func (o OnceHandlers) HandleCloseRoom(fun func(roomId string, rest []byte)) func() {
+	return o.c.handlers.closeRoomHandlers.add(fun, true)
+}
+
+// Triggered by the next Kick message, see Client.HandleKick.
+func (o OnceHandlers) HandleKick(fun func(roomId string, clientId string)) func() {
+	return o.c.handlers.kickHandlers.add(fun, true)
+}
@@ -0,0 +1,75 @@
+package axion
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHandlerList(t *testing.T) {
+	var l handlerList[func() string]
+	a := l.add(func() string { return "a" }, false)
+	l.add(func() string { return "b" }, true)
+	l.add(func() string { return "c" }, false)
+
+	call := func() string {
+		var got []string
+		for _, fun := range l.take() {
+			got = append(got, fun())
+		}
+		return strings.Join(got, ",")
+	}
+	if got := call(); got != "a,b,c" {
+		t.Errorf("got %s, want a,b,c", got)
+	}
+	if got := call(); got != "a,c" {
+		t.Errorf("one-shot handler still registered: %s", got)
+	}
+	a()
+	a()
+	if got := call(); got != "c" {
+		t.Errorf("got %s after unsubscribe, want c", got)
+	}
+	l.set(func() string { return "d" })
+	if got := call(); got != "d" {
+		t.Errorf("got %s after set, want d", got)
+	}
+}
+
+func TestHandlerListConcurrent(t *testing.T) {
+	var l handlerList[func()]
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				l.add(func() {}, j%2 == 0)()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				for _, fun := range l.take() {
+					fun()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(l.take()); n != 0 {
+		t.Errorf("%d handlers left", n)
+	}
+}
+
+func TestHandleBroadcast(t *testing.T) {
+	c := newClient(NewServer().hub, nil, "client")
+	var broadcasts, pongs int
+	c.HandlePong(func(p []byte) { pongs++ })
+	c.HandleBroadcast(func(p []byte) { broadcasts++ })
+
+	c.readFrame(Frame{Type: BroadCastMessage, Payload: []byte("hi")})
+	if broadcasts != 1 || pongs != 0 {
+		t.Errorf("got %d broadcasts and %d pongs, want 1 and 0", broadcasts, pongs)
+	}
+}
@@ -0,0 +1,87 @@
+package axion
+
+import (
+	"encoding/binary"
+	"slices"
+)
+
+// Optional protocol features announced by the server in the Init message and requested by the client in its
+// Status reply. A connection has the capabilities both sides name.
+type Capabilities uint32
+
+const (
+	// The server keeps sessions of lost connections and the client resumes them with the resume token.
+	CapRecovery Capabilities = 1 << iota
+	// Streams multiplexed over the connection.
+	CapStreams
+	// Requests and responses.
+	CapRPC
+)
+
+// Reports whether all capabilities of other are set.
+func (c Capabilities) Has(other Capabilities) bool {
+	return c&other == other
+}
+
+// Sets the protocol versions the server announces in the Init message. Version 1 is always spoken, as every
+// connection starts with it and clients which do not negotiate keep it. Defaults to all versions.
+func WithProtocolVersions(versions ...int) Option {
+	return func(o *serverOptions) {
+		o.versions = []int{ProtocolV1}
+		for _, version := range versions {
+			if version == ProtocolV2 && !slices.Contains(o.versions, version) {
+				o.versions = append(o.versions, version)
+			}
+		}
+	}
+}
+
+// Returns the protocol version spoken on the connection of the client.
+func (c *Client) ProtocolVersion() int {
+	return int(c.Connection().version.Load())
+}
+
+// Returns the capabilities negotiated on the connection of the client. Streams and requests are refused
+// without them. Clients which did not negotiate have all capabilities the server announces.
+func (c *Client) Capabilities() Capabilities {
+	return Capabilities(c.Connection().features.Load())
+}
+
+// Returns the capabilities the server announces.
+func (s *Server) capabilities() Capabilities {
+	capabilities := CapStreams | CapRPC
+	if s.options.sessionGracePeriod > 0 {
+		capabilities |= CapRecovery
+	}
+	return capabilities
+}
+
+// Returns the Init message for the current connection of the client.
+func (c *Client) initMessage() WsMessage {
+	s := c.hub.server
+	return NewInitMessage(c.id, c.ResumeToken(), s.options.versions, s.capabilities())
+}
+
+// Handles the Status reply to the Init message: version (1 byte) and the requested capabilities (4 bytes,
+// optional). Frames read afterwards and frames written afterwards are in the chosen version.
+func (c *Client) negotiate(p []byte) {
+	if c.parent != nil || c.negotiated.Load() {
+		c.clientError("protocol already negotiated")
+		return
+	}
+	version := int(p[0])
+	if !slices.Contains(c.hub.server.options.versions, version) {
+		c.clientError("unsupported protocol version")
+		return
+	}
+	var requested Capabilities
+	if len(p) >= 5 {
+		requested = Capabilities(binary.BigEndian.Uint32(p[1:]))
+	}
+	capabilities := requested & c.hub.server.capabilities()
+
+	c.negotiated.Store(true)
+	c.features.Store(uint32(capabilities))
+	c.version.Store(int32(version))
+	c.logger.Debug("protocol negotiated", "version", version, "capabilities", uint32(capabilities))
+}
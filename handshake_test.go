@@ -0,0 +1,148 @@
+package axion
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestNegotiation(t *testing.T) {
+	s := NewServer()
+	s.CreateRoomWithId("lobby")
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, p, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	init, err := DecodeFrame(ProtocolV1, p)
+	if err != nil || init.Type != SigInit {
+		t.Fatalf("expected init, got %+v, %v", init, err)
+	}
+	capabilities := Capabilities(binary.BigEndian.Uint32(init.Payload))
+	versions := init.Payload[5 : 5+int(init.Payload[4])]
+	if !slices.Equal(versions, []byte{ProtocolV1, ProtocolV2}) || !capabilities.Has(CapStreams|CapRPC) || capabilities.Has(CapRecovery) {
+		t.Fatalf("unexpected announcement: versions %v, capabilities %b", versions, capabilities)
+	}
+
+	write := func(version int, f Frame) {
+		p, err := EncodeFrame(version, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status := binary.BigEndian.AppendUint32([]byte{ProtocolV2}, uint32(CapRPC|CapRecovery))
+	write(ProtocolV1, Frame{Type: StatusMessage, Payload: status})
+	write(ProtocolV2, Frame{Type: JoinRoomMessage, RoomId: "lobby"})
+
+	_, p, err = conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined, err := DecodeFrame(ProtocolV2, p)
+	if err != nil || joined.Type != SigClientJoined || joined.RoomId != "lobby" || joined.ClientId != init.ClientId {
+		t.Fatalf("expected version 2 join signal, got %+v, %v", joined, err)
+	}
+
+	client, _ := s.GetClientById(init.ClientId)
+	if client.ProtocolVersion() != ProtocolV2 || client.Capabilities() != CapRPC {
+		t.Errorf("got version %d, capabilities %b", client.ProtocolVersion(), client.Capabilities())
+	}
+
+	// A second Status is refused.
+	write(ProtocolV2, Frame{Type: StatusMessage, Payload: []byte{ProtocolV1}})
+	_, p, _ = conn.ReadMessage()
+	if f, err := DecodeFrame(ProtocolV2, p); err != nil || f.Type != SigClientError {
+		t.Errorf("expected client error, got %+v, %v", f, err)
+	}
+}
+
+func TestCapabilitiesEnforced(t *testing.T) {
+	s := NewServer()
+	s.Namespace("/chat")
+	s.HandleConnect(func(client *Client, r *http.Request) {
+		client.HandleRequest("ping", func(ctx context.Context, payload []byte) ([]byte, error) {
+			return []byte("pong"), nil
+		})
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	// Returns a connection which negotiated the capabilities, or did not negotiate for a nil capabilities.
+	dial := func(capabilities *Capabilities) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		readInit(t, conn)
+		if capabilities != nil {
+			status := binary.BigEndian.AppendUint32([]byte{ProtocolV1}, uint32(*capabilities))
+			p, _ := EncodeFrame(ProtocolV1, Frame{Type: StatusMessage, Payload: status})
+			conn.WriteMessage(websocket.BinaryMessage, p)
+		}
+		return conn
+	}
+	read := func(conn *websocket.Conn) Frame {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, p, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, _ := DecodeFrame(ProtocolV1, p)
+		return f
+	}
+	openStream := func(conn *websocket.Conn) Frame {
+		p, _ := EncodeFrame(ProtocolV1, Frame{Type: OpenStreamMessage, Payload: append([]byte{0, 0, 0, 1}, "/chat"...)})
+		conn.WriteMessage(websocket.BinaryMessage, p)
+		return read(conn)
+	}
+	request := func(conn *websocket.Conn) Frame {
+		p, _ := EncodeFrame(ProtocolV1, Frame{Type: RequestMessage, Payload: append([]byte{0, 0, 0, 7, 0, 4}, "ping"...)})
+		conn.WriteMessage(websocket.BinaryMessage, p)
+		return read(conn)
+	}
+
+	rpcOnly, streamsOnly := CapRPC, CapStreams
+	conn := dial(&rpcOnly)
+	if f := openStream(conn); f.Type != SigStreamRejected || string(f.Payload[4:]) != "streams not negotiated" {
+		t.Errorf("stream without CapStreams: got %#x %q", f.Type, f.Payload)
+	}
+	if f := request(conn); f.Type != ResponseMessage || string(f.Payload) != "\x00\x00\x00\x07\x00\x00\x00\x00pong" {
+		t.Errorf("request with CapRPC: got %#x %q", f.Type, f.Payload)
+	}
+
+	conn = dial(&streamsOnly)
+	if f := request(conn); f.Type != ResponseMessage || binary.BigEndian.Uint32(f.Payload[4:]) != SigClientError || string(f.Payload[8:]) != "requests not negotiated" {
+		t.Errorf("request without CapRPC: got %#x %q", f.Type, f.Payload)
+	}
+	if f := openStream(conn); f.Type != SigStreamOpened {
+		t.Errorf("stream with CapStreams: got %#x %q", f.Type, f.Payload)
+	}
+
+	// Clients which do not negotiate keep streams and requests.
+	conn = dial(nil)
+	if f := openStream(conn); f.Type != SigStreamOpened {
+		t.Errorf("stream without negotiation: got %#x %q", f.Type, f.Payload)
+	}
+	if f := request(conn); f.Type != ResponseMessage || string(f.Payload) != "\x00\x00\x00\x07\x00\x00\x00\x00pong" {
+		t.Errorf("request without negotiation: got %#x %q", f.Type, f.Payload)
+	}
+}
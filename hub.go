@@ -1,8 +1,6 @@
 package axion
 
 import (
-	axlog "axion/log"
-	"fmt"
 	"net/http"
 	"sync"
 
@@ -10,30 +8,48 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-// TODO Recover sessions
-
 type RegisterClient struct {
+	conn        *websocket.Conn
+	r           *http.Request
+	resumeToken string
+	identity    *Identity
+}
+
+// A nil conn terminates the session of the client for the given reason, otherwise conn is the connection which got lost
+// and reason optionally tells why.
+type UnregisterClient struct {
 	client *Client
-	r      *http.Request
+	conn   *websocket.Conn
+	reason string
+	status CloseStatus
 }
 
 type Hub struct {
 	server     *Server
+	namespace  *Namespace
 	clients    map[string]*Client
+	sessions   map[string]*Client
 	rooms      map[string]*Room
-	broadcast  chan WsMessage
 	register   chan *RegisterClient
-	unregister chan *Client
+	unregister chan *UnregisterClient
+	expire     chan *Client
+	stop       chan chan []*Client
+	done       chan struct{}
+	empty      chan struct{}
 	mu         sync.RWMutex
 }
 
-func newHub(server *Server) *Hub {
+func newHub(server *Server, namespace *Namespace) *Hub {
 	return &Hub{
-		broadcast:  make(chan WsMessage),
+		namespace:  namespace,
 		rooms:      make(map[string]*Room),
 		register:   make(chan *RegisterClient),
-		unregister: make(chan *Client),
+		unregister: make(chan *UnregisterClient),
+		expire:     make(chan *Client),
+		stop:       make(chan chan []*Client),
+		done:       make(chan struct{}),
 		clients:    make(map[string]*Client),
+		sessions:   make(map[string]*Client),
 		server:     server,
 	}
 }
@@ -70,86 +86,247 @@ func (h *Hub) getRoomById(id string) *Room {
 	return h.rooms[id]
 }
 
+func (h *Hub) createRoom() *Room {
+	room, _ := h.createRoomWithId(uuid.New().String())
+	return room
+}
+
+func (h *Hub) createRoomWithId(id string) (*Room, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, exists := h.rooms[id]; exists {
+		return nil, false
+	}
+	room := newRoom(id, h)
+	h.rooms[id] = room
+	h.server.options.logger.Debug("room opened", "room_id", id, "namespace", h.namespace.name)
+	return room, true
+}
+
+// Sends the message to all clients of the hub. Like Room.BroadcastMessage the message is handed to the clients
+// from the calling go routine, so a client blocking under PolicyBlock never stalls the hub.
 func (h *Hub) broadcastMessage(message WsMessage) {
-	h.broadcast <- message
+	clients := h.getClients()
+	h.server.metrics.fanout.observe(len(clients))
+	for _, client := range clients {
+		client.SendMessage(message)
+	}
+}
+
+// Returns a channel which is closed once no clients are connected.
+func (h *Hub) drained() <-chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.empty == nil {
+		h.empty = make(chan struct{})
+	}
+	if len(h.clients) == 0 {
+		close(h.empty)
+		h.empty = nil
+		return closedChan
+	}
+	return h.empty
+}
+
+var closedChan = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+// Stops the hub. Returns the clients which were connected.
+func (h *Hub) shutdown() []*Client {
+	reply := make(chan []*Client)
+	select {
+	case h.stop <- reply:
+		return <-reply
+	case <-h.done:
+		return nil
+	}
 }
 
 func (h *Hub) run() {
 	for {
 		select {
+		case reply := <-h.stop:
+			reply <- h.closeAll()
+			close(h.done)
+			return
 		case reg := <-h.register:
+			h.registerClient(reg)
+		case unreg := <-h.unregister:
+			h.unregisterClient(unreg)
+		case client := <-h.expire:
 			h.mu.Lock()
-			axlog.Loglf("register client %s", reg.client.id)
-
-			h.clients[reg.client.id] = reg.client
-
-			h.server.handlers.connectHandler(reg.client, reg.r)
-			h.mu.Unlock()
-		case client := <-h.unregister:
-			h.mu.Lock()
-			if _, ok := h.clients[client.id]; ok {
-				axlog.Loglf("unregister client %s", client.id)
-
-				delete(h.clients, client.id)
-				close(client.send)
-
-				client.handlers.disconnectHandler()
+			expired := client.Detached() && h.clients[client.id] == client
+			if expired {
+				client.logger.Info("session expired")
+				client.setCloseStatus(CloseStatus{Code: websocket.CloseAbnormalClosure, Reason: "session expired", Initiator: InitiatorServer})
+				h.removeClient(client, "expired")
 			}
 			h.mu.Unlock()
-		case message := <-h.broadcast:
-			h.mu.Lock()
-			for clientID, client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, clientID)
-				}
+			if expired {
+				client.onDisconnect()
 			}
-			h.mu.Unlock()
-		default:
-			h.mu.RLock()
-			for _, room := range h.rooms {
-				select {
-				case message := <-room.broadcast:
-					room.mu.RLock()
-					for _, client := range room.clients {
-						client.send <- message
-					}
-					room.mu.RUnlock()
-				default:
-				}
-			}
-			h.mu.RUnlock()
 		}
 	}
 }
 
-var upgrader = websocket.Upgrader{
-	WriteBufferSize: 1024,
-	ReadBufferSize:  1024,
-	CheckOrigin:     func(r *http.Request) bool { return true },
+func (h *Hub) registerClient(reg *RegisterClient) {
+	h.mu.Lock()
+	if client, ok := h.sessions[reg.resumeToken]; ok && reg.resumeToken != "" && sameUser(client.identity, reg.identity) {
+		h.resumeClient(client, reg.conn)
+		h.mu.Unlock()
+		client.protect("resume", func() {
+			for _, handler := range client.handlers.resumeHandlers.take() {
+				handler()
+			}
+		})
+		go client.readPump(reg.conn)
+		return
+	}
+
+	client := newClient(h, reg.conn, uuid.New().String())
+	client.request = reg.r
+	client.identity = reg.identity
+	client.logger.Info("client connected")
+	h.clients[client.id] = client
+	go client.writePump()
+	h.attachClient(client, reg.conn)
+	h.mu.Unlock()
+
+	client.protect("connect", func() {
+		h.namespace.handlers.connectHandler(client, reg.r)
+	})
+	go client.readPump(reg.conn)
+}
+
+func (h *Hub) unregisterClient(unreg *UnregisterClient) {
+	client := unreg.client
+
+	h.mu.Lock()
+	if h.clients[client.id] != client {
+		h.mu.Unlock()
+		return
+	}
+	client.mu.RLock()
+	stale := unreg.conn != nil && (unreg.conn != client.conn || client.detached)
+	endReason := client.endReason
+	client.mu.RUnlock()
+	if stale {
+		h.mu.Unlock()
+		return
+	}
+	if unreg.conn != nil && endReason == "" && unreg.status.Code == websocket.CloseAbnormalClosure && h.recoveryEnabled() {
+		h.detachClient(client)
+		h.mu.Unlock()
+		return
+	}
+
+	// The connection of a client whose session the server ends may be lost before the hub learns why.
+	reason := unreg.reason
+	if endReason != "" {
+		reason = endReason
+	} else if unreg.conn != nil && reason == "" {
+		reason = "connection_lost"
+	}
+	client.setCloseStatus(unreg.status)
+	status := client.CloseReason()
+	client.logger.Info("client disconnected", "reason", reason, "code", status.Code, "initiator", status.Initiator.String())
+	h.removeClient(client, reason)
+	h.mu.Unlock()
+
+	client.onDisconnect()
+}
+
+// Ends the session of the client. Must be called with the hub lock held.
+func (h *Hub) removeClient(client *Client, reason string) {
+	if client.parent == nil {
+		h.server.metrics.disconnects[reason].Add(1)
+	}
+
+	client.mu.Lock()
+	if client.graceTimer != nil {
+		client.graceTimer.Stop()
+		client.graceTimer = nil
+	}
+	delete(h.sessions, client.resumeToken)
+	client.mu.Unlock()
+
+	delete(h.clients, client.id)
+	client.queue.close()
+
+	if h.empty != nil && len(h.clients) == 0 {
+		close(h.empty)
+		h.empty = nil
+	}
+}
+
+// Closes every client with the shutdown close frame after its pending messages and removes all rooms.
+func (h *Hub) closeAll() []*Client {
+	code, reason := sanitizeClose(h.server.options.closeCode, h.server.options.closeReason)
+	closeFrame := websocket.FormatCloseMessage(code, reason)
+
+	h.mu.Lock()
+	clients := make([]*Client, 0, len(h.clients))
+	for _, client := range h.clients {
+		client.mu.Lock()
+		if client.closeFrame == nil {
+			client.closeFrame = closeFrame
+		}
+		client.mu.Unlock()
+		client.setCloseStatus(CloseStatus{Code: code, Reason: reason, Initiator: InitiatorServer})
+
+		h.removeClient(client, "shutdown")
+		clients = append(clients, client)
+	}
+	for id, room := range h.rooms {
+		room.mu.Lock()
+		room.clients = make([]*Client, 0)
+		room.mu.Unlock()
+		delete(h.rooms, id)
+	}
+	h.mu.Unlock()
+
+	for _, client := range clients {
+		client.mu.Lock()
+		client.rooms = make([]*Room, 0)
+		client.mu.Unlock()
+		client.onDisconnect()
+	}
+	return clients
 }
 
 func (hub *Hub) handleNewConnection(w http.ResponseWriter, r *http.Request) {
+	accepted := false
 	connect := func() {
-		clientId := uuid.New().String()
-
-		conn, err := upgrader.Upgrade(w, r, http.Header{})
+		accepted = true
+		conn, err := hub.server.upgrader.Upgrade(w, r, nil)
 		if err != nil {
-			fmt.Println(err)
+			hub.server.options.logger.Warn("upgrade failed", "remote_addr", r.RemoteAddr, "error", err)
+			hub.server.metrics.upgradesRejected.Add(1)
 			return
 		}
-		axlog.Loglf("new client: %s", r.RemoteAddr)
-
-		client := newClient(hub, conn, clientId)
-		hub.register <- &RegisterClient{client: client, r: r}
-
-		go client.readPump()
-		go client.writePump()
+		hub.server.metrics.upgrades.Add(1)
+		hub.server.options.logger.Debug("upgraded", "remote_addr", r.RemoteAddr)
 
-		client.SendMessage(NewInitMessage(clientId))
+		reg := &RegisterClient{
+			conn:        conn,
+			r:           r,
+			resumeToken: r.URL.Query().Get(ResumeTokenParam),
+		}
+		reg.identity, _ = IdentityFromContext(r.Context())
+		select {
+		case hub.register <- reg:
+		case <-hub.done:
+			conn.Close()
+		}
 	}
 
 	hub.server.handlers.upgradeHandler(w, r, connect)
+	if !accepted {
+		hub.server.options.logger.Debug("upgrade rejected", "remote_addr", r.RemoteAddr)
+		hub.server.metrics.upgradesRejected.Add(1)
+	}
 }
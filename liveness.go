@@ -0,0 +1,99 @@
+package axion
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultReadLimit    = 1 << 20
+	defaultPongWait     = 60 * time.Second
+	defaultWriteTimeout = 10 * time.Second
+)
+
+// Sets the maximum size in bytes of a message a client may send. Connections sending larger messages are closed
+// with 1009 (message too big). Defaults to 1 MiB, a negative limit disables it.
+func WithReadLimit(limit int64) Option {
+	return func(o *serverOptions) {
+		o.readLimit = limit
+	}
+}
+
+// Sets how often clients are pinged and how long the server waits for a pong or any other message before it
+// considers the peer dead and closes the connection. pingPeriod has to be positive and shorter than pongWait,
+// otherwise the option is ignored. Defaults to a ping every 54 seconds and a wait of 60 seconds.
+func WithKeepalive(pingPeriod time.Duration, pongWait time.Duration) Option {
+	return func(o *serverOptions) {
+		if pingPeriod <= 0 || pingPeriod >= pongWait {
+			return
+		}
+		o.pingPeriod = pingPeriod
+		o.pongWait = pongWait
+	}
+}
+
+// Sets the time a single write may take before the connection is considered dead. Defaults to 10 seconds,
+// a timeout of zero or less is ignored.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(o *serverOptions) {
+		if timeout > 0 {
+			o.writeTimeout = timeout
+		}
+	}
+}
+
+// Returns the round trip time measured by the last ping, 0 if no pong arrived yet.
+func (c *Client) Latency() time.Duration {
+	return time.Duration(c.Connection().latency.Load())
+}
+
+// Prepares a new connection for reading: applies the read limit, sets the first read deadline and
+// measures the round trip time of pings before the pong handlers run.
+func (c *Client) watchConnection(conn *websocket.Conn) {
+	opts := c.hub.server.options
+	if opts.readLimit > 0 {
+		conn.SetReadLimit(opts.readLimit)
+	}
+	c.extendReadDeadline(conn)
+	conn.SetCloseHandler(c.handleClose(conn))
+	conn.SetPongHandler(func(appData string) error {
+		c.extendReadDeadline(conn)
+		if len(appData) == 8 {
+			sent := int64(binary.BigEndian.Uint64([]byte(appData)))
+			if sent == c.lastPing.Load() {
+				c.latency.Store(time.Now().UnixNano() - sent)
+			}
+		}
+		c.protect("pong", func() {
+			for _, handler := range c.handlers.pongHandlers.take() {
+				handler([]byte(appData))
+			}
+		})
+		return nil
+	})
+}
+
+func (c *Client) extendReadDeadline(conn *websocket.Conn) {
+	if c.closing.Load() {
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(c.hub.server.options.pongWait))
+}
+
+// Sends a ping carrying the send time, which the pong echoes.
+func (c *Client) ping(conn *websocket.Conn) error {
+	now := time.Now()
+	c.lastPing.Store(now.UnixNano())
+	payload := binary.BigEndian.AppendUint64(nil, uint64(now.UnixNano()))
+	return conn.WriteControl(websocket.PingMessage, payload, now.Add(c.hub.server.options.writeTimeout))
+}
+
+// Reports whether the read failed because the peer stopped answering.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,104 @@
+package axion
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestLatency(t *testing.T) {
+	s := NewServer(WithKeepalive(20*time.Millisecond, time.Second))
+	clients := make(chan *Client, 1)
+	pongs := make(chan []byte, 16)
+	s.HandleConnect(func(client *Client, r *http.Request) {
+		client.HandlePong(func(p []byte) {
+			select {
+			case pongs <- p:
+			default:
+			}
+		})
+		clients <- client
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Reading answers the pings of the server.
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	client := <-clients
+
+	select {
+	case p := <-pongs:
+		if len(p) != 8 {
+			t.Errorf("pong payload of %d bytes", len(p))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("pong handler not called")
+	}
+	if latency := client.Latency(); latency <= 0 || latency > time.Second {
+		t.Errorf("latency %v after a ping round trip", latency)
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	s := NewServer(WithReadLimit(16))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	readInit(t, conn)
+
+	conn.WriteMessage(websocket.TextMessage, []byte("small"))
+	conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("too big ", 4)))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseMessageTooBig {
+		t.Errorf("got %v, want close 1009", err)
+	}
+}
+
+func TestWithKeepalive(t *testing.T) {
+	tests := []struct {
+		pingPeriod time.Duration
+		pongWait   time.Duration
+		valid      bool
+	}{
+		{time.Second, 2 * time.Second, true},
+		{0, time.Second, false},
+		{-time.Second, time.Second, false},
+		{time.Second, 0, false},
+		{time.Second, -time.Second, false},
+		{2 * time.Second, time.Second, false},
+		{time.Second, time.Second, false},
+	}
+	for _, test := range tests {
+		o := NewServer(WithKeepalive(test.pingPeriod, test.pongWait)).options
+		want := [2]time.Duration{defaultPongWait * 9 / 10, defaultPongWait}
+		if test.valid {
+			want = [2]time.Duration{test.pingPeriod, test.pongWait}
+		}
+		if got := [2]time.Duration{o.pingPeriod, o.pongWait}; got != want {
+			t.Errorf("WithKeepalive(%v, %v): got %v, want %v", test.pingPeriod, test.pongWait, got, want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package axion
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Sets the logger for lifecycle events of clients, rooms and the server. Message contents are not logged
+// unless WithPayloadLogging is set. Defaults to discarding all output, as does a nil logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *serverOptions) {
+		if logger == nil {
+			logger = slog.New(discardHandler{})
+		}
+		o.logger = logger
+	}
+}
+
+// Logs the contents of received messages at debug level.
+func WithPayloadLogging() Option {
+	return func(o *serverOptions) {
+		o.logPayloads = true
+	}
+}
+
+// Returns the logger of the server.
+func (s *Server) Logger() *slog.Logger {
+	return s.options.logger
+}
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
@@ -0,0 +1,153 @@
+package axion
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type logRecord struct {
+	level   slog.Level
+	message string
+	attrs   map[string]any
+}
+
+// Collects all records, including the attributes added by derived loggers.
+type captureHandler struct {
+	mu      *sync.Mutex
+	records *[]logRecord
+	attrs   []slog.Attr
+}
+
+func newCaptureHandler() *captureHandler {
+	return &captureHandler{mu: new(sync.Mutex), records: new([]logRecord)}
+}
+
+func (h *captureHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *captureHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := make(map[string]any)
+	for _, attr := range h.attrs {
+		attrs[attr.Key] = attr.Value.Any()
+	}
+	r.Attrs(func(attr slog.Attr) bool {
+		attrs[attr.Key] = attr.Value.Any()
+		return true
+	})
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	*h.records = append(*h.records, logRecord{level: r.Level, message: r.Message, attrs: attrs})
+	return nil
+}
+
+func (h *captureHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &captureHandler{mu: h.mu, records: h.records, attrs: append(slices.Clip(h.attrs), attrs...)}
+}
+
+func (h *captureHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// Returns the first record with the message whose attributes include attrs. A nil value only
+// requires the attribute to be present.
+func (h *captureHandler) find(message string, attrs map[string]any) (logRecord, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, record := range *h.records {
+		if record.message == message && matchAttrs(record.attrs, attrs) {
+			return record, true
+		}
+	}
+	return logRecord{}, false
+}
+
+func matchAttrs(got map[string]any, want map[string]any) bool {
+	for key, value := range want {
+		attr, ok := got[key]
+		if !ok || value != nil && fmt.Sprint(attr) != fmt.Sprint(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Waits for a matching record and checks its level.
+func (h *captureHandler) expect(t *testing.T, level slog.Level, message string, attrs map[string]any) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	record, ok := h.find(message, attrs)
+	for ; !ok && time.Now().Before(deadline); record, ok = h.find(message, attrs) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !ok {
+		t.Errorf("no %q record with %v", message, attrs)
+		return
+	}
+	if record.level != level {
+		t.Errorf("%q logged at %s, want %s", message, record.level, level)
+	}
+}
+
+func TestLogging(t *testing.T) {
+	for _, payloads := range []bool{false, true} {
+		handler := newCaptureHandler()
+		opts := []Option{WithLogger(slog.New(handler))}
+		if payloads {
+			opts = append(opts, WithPayloadLogging())
+		}
+		s := NewServer(opts...)
+		s.Namespace("/chat")
+		ts := httptest.NewServer(s)
+
+		room := s.CreateRoom()
+		handler.expect(t, slog.LevelDebug, "room opened", map[string]any{"room_id": room.Id(), "namespace": "/"})
+		room.Close()
+		handler.expect(t, slog.LevelDebug, "room closed", map[string]any{"room_id": room.Id(), "namespace": "/"})
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := readInit(t, conn)
+		handler.expect(t, slog.LevelInfo, "client connected", map[string]any{"client_id": id, "remote_addr": nil})
+
+		open := binary.BigEndian.AppendUint32(nil, OpenStreamMessage)
+		open = binary.BigEndian.AppendUint32(open, 1)
+		conn.WriteMessage(websocket.BinaryMessage, append(open, "/chat"...))
+		handler.expect(t, slog.LevelInfo, "stream opened", map[string]any{"client_id": id, "remote_addr": nil, "namespace": "/chat", "stream_id": uint64(1)})
+
+		conn.WriteMessage(websocket.TextMessage, []byte("secret"))
+		if payloads {
+			handler.expect(t, slog.LevelDebug, "message received", map[string]any{"client_id": id, "content": []byte("secret")})
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		s.Drain(ctx)
+		cancel()
+		handler.expect(t, slog.LevelInfo, "draining", nil)
+
+		conn.Close()
+		handler.expect(t, slog.LevelInfo, "client disconnected", map[string]any{"client_id": id, "remote_addr": nil})
+		ts.Close()
+
+		if _, ok := handler.find("message received", nil); ok != payloads {
+			t.Errorf("payload logged: %v, want %v", ok, payloads)
+		}
+	}
+}
+
+func TestWithNilLogger(t *testing.T) {
+	s := NewServer(WithLogger(nil))
+	c := newClient(s.hub, nil, "client")
+	c.logger.Info("not logged")
+	s.Logger().Info("not logged")
+}
@@ -1,8 +1,8 @@
 package axion
 
 import (
-	axlog "axion/log"
 	"encoding/binary"
+	"errors"
 
 	"github.com/gorilla/websocket"
 )
@@ -15,6 +15,17 @@ const (
 	LeaveRoomMessage = 0x1EAFE300
 	OpenRoomMessage  = 0x09E14300
 	CloseRoomMessage = 0xC105E300
+	KickMessage      = 0x1C1C0300
+
+	StreamMessage      = 0x57EA3300
+	OpenStreamMessage  = 0x09E15300
+	CloseStreamMessage = 0xC105E500
+
+	RequestMessage  = 0x3E9E5700
+	ResponseMessage = 0x3E5E0300
+
+	// Sent in both directions.
+	EventMessage = 0xE7E47000
 )
 
 const (
@@ -24,11 +35,17 @@ const (
 	SigRoomAbandoned = 0xABAD0300
 	SigClientLeft    = 0x1EF70300
 	SigClientJoined  = 0x101ED300
+	SigAccessDenied  = 0xDE41ED00
+
+	SigStreamOpened   = 0x09E1ED00
+	SigStreamClosed   = 0xC105ED00
+	SigStreamRejected = 0x3E1EC7ED
 )
 
 type WsMessage struct {
 	msgType int
 	content []byte
+	frame   *Frame
 }
 
 // Creates a new message
@@ -55,198 +72,332 @@ func NewBinaryMessage(message []byte) WsMessage {
 	}
 }
 
-func NewInitMessage(clientId string) WsMessage {
-	var p []byte
-	binary.BigEndian.AppendUint32(p, SigInit)
-	p = append(p, []byte(clientId)...)
+// Creates a binary message carrying a protocol frame, which is encoded in the protocol version of each recipient.
+func NewFrameMessage(f Frame) WsMessage {
+	content, _ := EncodeFrame(ProtocolV1, f)
 	return WsMessage{
-		msgType: 0,
-		content: p,
+		msgType: websocket.BinaryMessage,
+		content: content,
+		frame:   &f,
+	}
+}
+
+// Returns the websocket message type and the bytes written for the message on a connection using the codec.
+func (m WsMessage) encode(codec Codec) (int, []byte, error) {
+	if m.frame == nil || codec == (binaryCodec{version: ProtocolV1}) && m.content != nil {
+		return m.msgType, m.content, nil
+	}
+	p, err := codec.Encode(*m.frame)
+	return codec.MessageType(), p, err
+}
+
+// Creates a new Init message: capabilities (4 bytes), number of versions (1 byte), the supported protocol
+// versions (1 byte each) and the resume token. The resume token is omitted if empty.
+func NewInitMessage(clientId string, resumeToken string, versions []int, capabilities Capabilities) WsMessage {
+	p := binary.BigEndian.AppendUint32(nil, uint32(capabilities))
+	p = append(p, byte(len(versions)))
+	for _, version := range versions {
+		p = append(p, byte(version))
 	}
+	p = append(p, resumeToken...)
+	return NewFrameMessage(Frame{Type: SigInit, ClientId: clientId, Payload: p})
 }
 
 // Creates a new ClientError message
 func NewClientErrorMessage(message string) WsMessage {
-	var p []byte
-	binary.BigEndian.AppendUint32(p, SigClientError)
-	p = append(p, []byte(message)...)
-	return WsMessage{
-		msgType: 0,
-		content: p,
-	}
+	return NewFrameMessage(Frame{Type: SigClientError, Payload: []byte(message)})
 }
 
 // Creates a new ServerError message
 func NewServerErrorMessage(message string) WsMessage {
-	var p []byte
-	binary.BigEndian.AppendUint32(p, SigServerError)
-	p = append(p, []byte(message)...)
-	return WsMessage{
-		msgType: 0,
-		content: p,
-	}
+	return NewFrameMessage(Frame{Type: SigServerError, Payload: []byte(message)})
 }
 
 // Creates a new RoomAbandoned message
 func NewRoomAbandonedMessage(roomId string) WsMessage {
-	var p []byte
-	binary.BigEndian.AppendUint32(p, SigRoomAbandoned)
-	p = append(p, []byte(roomId)...)
-	return WsMessage{
-		msgType: 0,
-		content: p,
-	}
+	return NewFrameMessage(Frame{Type: SigRoomAbandoned, RoomId: roomId})
 }
 
 // Creates a new ClientLeftRoom message
 func NewClientLeftMessage(roomId string, clientId string) WsMessage {
-	var p []byte
-	binary.BigEndian.AppendUint32(p, SigClientLeft)
-	p = append(p, []byte(roomId)...)
-	p = append(p, []byte(clientId)...)
-	return WsMessage{
-		msgType: 0,
-		content: p,
-	}
+	return NewFrameMessage(Frame{Type: SigClientLeft, RoomId: roomId, ClientId: clientId})
 }
 
 // Creates a new ClientJoinedRoom message
 func NewClientJoinedMessage(roomId string, clientId string) WsMessage {
-	var p []byte
-	binary.BigEndian.AppendUint32(p, SigClientJoined)
-	p = append(p, []byte(roomId)...)
-	p = append(p, []byte(clientId)...)
-	return WsMessage{
-		msgType: 0,
-		content: p,
+	return NewFrameMessage(Frame{Type: SigClientJoined, RoomId: roomId, ClientId: clientId})
+}
+
+// Creates a new AccessDenied message: room id, action (1 byte) and reason. The room id is empty for ActionOpen.
+func NewAccessDeniedMessage(err *AccessError) WsMessage {
+	payload := append([]byte{byte(err.Action)}, err.Reason...)
+	return NewFrameMessage(Frame{Type: SigAccessDenied, RoomId: err.RoomId, Payload: payload})
+}
+
+// Creates a new StreamOpened message
+func NewStreamOpenedMessage(streamId uint32) WsMessage {
+	p := binary.BigEndian.AppendUint32(nil, streamId)
+	return NewFrameMessage(Frame{Type: SigStreamOpened, Payload: p})
+}
+
+// Creates a new StreamClosed message
+func NewStreamClosedMessage(streamId uint32, code int, reason string) WsMessage {
+	p := binary.BigEndian.AppendUint32(nil, streamId)
+	p = binary.BigEndian.AppendUint16(p, uint16(code))
+	p = append(p, []byte(reason)...)
+	return NewFrameMessage(Frame{Type: SigStreamClosed, Payload: p})
+}
+
+// Creates a new StreamRejected message
+func NewStreamRejectedMessage(streamId uint32, reason string) WsMessage {
+	p := binary.BigEndian.AppendUint32(nil, streamId)
+	p = append(p, []byte(reason)...)
+	return NewFrameMessage(Frame{Type: SigStreamRejected, Payload: p})
+}
+
+// Wraps a message of a stream: stream id, websocket message type (1 byte) and content. The content is
+// encoded with the codec of the connection.
+func newStreamMessage(streamId uint32, message WsMessage, codec Codec) (WsMessage, error) {
+	msgType, content, err := message.encode(codec)
+	if err != nil {
+		return WsMessage{}, err
 	}
+	p := make([]byte, 0, 5+len(content))
+	p = binary.BigEndian.AppendUint32(p, streamId)
+	p = append(p, byte(msgType))
+	p = append(p, content...)
+	return NewFrameMessage(Frame{Type: StreamMessage, Payload: p}), nil
 }
 
-func (c *Client) readMessage() error {
-	msgType, message, err := c.conn.ReadMessage()
+// Reports a protocol error to the client.
+func (c *Client) clientError(message string) {
+	c.logger.Debug("protocol error", "error", message)
+	c.SendMessage(NewClientErrorMessage(message))
+}
+
+func (c *Client) readMessage(conn *websocket.Conn) error {
+	msgType, message, err := conn.ReadMessage()
 	if err != nil {
 		return err
 	}
-	axlog.Loglf("received message: type: %d, content: %s", msgType, string(message))
+	if c.hub.server.options.logPayloads {
+		c.logger.Debug("message received", "type", msgType, "size", len(message), "content", message)
+	}
+	if c.hub.server.options.rateLimit != nil {
+		if scope := c.checkRateLimit(msgType, message); scope != "" {
+			c.rateLimited(scope, msgType, message)
+			return nil
+		}
+	}
 
-	switch msgType {
+	c.handleMessage(msgType, message)
+	return nil
+}
+
+func (c *Client) handleMessage(msgType int, message []byte) {
+	var frame Frame
+	err := ErrUnknownFrame
+	if codec := c.Codec(); msgType == codec.MessageType() {
+		frame, err = codec.Decode(message)
+	}
+	c.countReceived(receivedKind(msgType, frame.Type, err == nil), len(message))
+	switch {
+	case errors.Is(err, ErrUnknownFrame):
+		frame = Frame{Payload: message}
+	case err != nil:
+		c.clientError(err.Error())
+		return
+	}
+
+	c.protect("message", func() {
+		c.dispatch(&Message{Client: c, MsgType: msgType, Frame: frame})
+	})
+}
+
+// Hands a message which passed the middleware to the handlers.
+func (c *Client) handleInbound(m *Message) {
+	if m.Type != 0 {
+		if err := m.Frame.validate(); err != nil {
+			c.logger.Warn("invalid frame from middleware dropped", "error", err)
+			return
+		}
+		c.readFrame(m.Frame)
+		return
+	}
+
+	message := m.Payload
+	switch m.MsgType {
 	case websocket.BinaryMessage:
-		c.readBinaryMessage(message)
+		for _, handler := range c.handlers.binaryHandlers.take() {
+			handler(message)
+		}
 	case websocket.TextMessage:
-		for _, handler := range c.handlers.textHandlers {
+		for _, handler := range c.handlers.textHandlers.take() {
 			handler(string(message))
 		}
 	case websocket.CloseMessage:
-		for _, handler := range c.handlers.closeHandlers {
+		for _, handler := range c.handlers.closeHandlers.take() {
 			handler(message)
 		}
 	case websocket.PingMessage:
-		for _, handler := range c.handlers.pingHandlers {
+		for _, handler := range c.handlers.pingHandlers.take() {
 			handler(message)
 		}
 	case websocket.PongMessage:
-		for _, handler := range c.handlers.pongHandlers {
+		for _, handler := range c.handlers.pongHandlers.take() {
 			handler(message)
 		}
 	default:
-		c.send <- NewClientErrorMessage("invalid message type")
+		c.clientError("invalid message type")
 	}
-	return nil
 }
 
-func (c *Client) readBinaryMessage(p []byte) {
-	special := p[:4]
-	rest := p[4:]
+// Dispatches a protocol frame.
+func (c *Client) readFrame(frame Frame) {
+	roomId, rest := frame.RoomId, frame.Payload
 
-	switch int(binary.BigEndian.Uint32(special)) {
+	switch frame.Type {
 	case BroadCastMessage:
-		if len(c.handlers.broadcastHandlers) == 0 {
+		handlers := c.handlers.broadcastHandlers.take()
+		if len(handlers) == 0 {
 			c.hub.broadcastMessage(NewBinaryMessage(rest))
 		}
-		for _, handler := range c.handlers.broadcastHandlers {
-			handler(p)
+		for _, handler := range handlers {
+			handler(rest)
 		}
 	case RoomMessage:
-		roomId := string(rest[:36])
-		message := rest[36:]
-		if len(c.handlers.roomMessageHandlers) == 0 {
+		handlers := c.handlers.roomMessageHandlers.take()
+		if len(handlers) == 0 {
 			room, exists := c.GetRoom(roomId)
 			if !exists {
-				c.SendMessage(NewClientErrorMessage("room not found"))
+				c.clientError("room not found")
 				return
 			}
-			room.Broadcast(0, message)
+			if err := room.Authorize(c, ActionSend, nil); err != nil {
+				c.accessDenied(err)
+				return
+			}
+			room.Broadcast(websocket.BinaryMessage, rest)
 		}
-		for _, handler := range c.handlers.roomMessageHandlers {
-			handler(roomId, message)
+		for _, handler := range handlers {
+			handler(roomId, rest)
 		}
 	case JoinRoomMessage:
-		roomId := string(rest[:36])
-		if len(c.handlers.joinHandlers) == 0 {
-			room, exists := c.hub.server.GetRoomById(roomId)
+		handlers := c.handlers.joinHandlers.take()
+		if len(handlers) == 0 {
+			room, exists := c.hub.namespace.GetRoomById(roomId)
 			if !exists {
-				c.SendMessage(NewClientErrorMessage("room not found"))
+				c.clientError("room not found")
+				return
+			}
+			if _, joined := c.GetRoom(roomId); joined {
+				return
+			}
+			if err := room.Authorize(c, ActionJoin, rest); err != nil {
+				c.accessDenied(err)
 				return
 			}
 			c.JoinRoom(room)
 		}
-		for _, handler := range c.handlers.joinHandlers {
-			handler(roomId, rest[36:])
+		for _, handler := range handlers {
+			handler(roomId, rest)
 		}
 	case LeaveRoomMessage:
-		roomId := string(rest[:36])
-		if len(c.handlers.leaveHandlers) == 0 {
+		handlers := c.handlers.leaveHandlers.take()
+		if len(handlers) == 0 {
 			room, exists := c.GetRoom(roomId)
 			if !exists {
-				c.SendMessage(NewClientErrorMessage("room not found"))
+				c.clientError("room not found")
 				return
 			}
 			c.LeaveRoom(room)
 		}
-		for _, handler := range c.handlers.leaveHandlers {
-			handler(roomId, rest[36:])
+		for _, handler := range handlers {
+			handler(roomId, rest)
 		}
 	case OpenRoomMessage:
 		joinAfterwards := rest[0] != 0
-		if len(c.handlers.openRoomHandlers) == 0 {
-			room := c.hub.server.CreateRoom()
+		handlers := c.handlers.openRoomHandlers.take()
+		if len(handlers) == 0 {
+			room, err := c.hub.namespace.openRoom(c)
+			if err != nil {
+				c.accessDenied(err)
+				return
+			}
 			if joinAfterwards {
 				c.JoinRoom(room)
 			}
 		}
-		for _, handler := range c.handlers.openRoomHandlers {
+		for _, handler := range handlers {
 			handler(joinAfterwards, rest[1:])
 		}
 	case CloseRoomMessage:
-		roomId := string(rest[:36])
-		if len(c.handlers.closeRoomHandlers) == 0 {
+		handlers := c.handlers.closeRoomHandlers.take()
+		if len(handlers) == 0 {
 			room, exists := c.GetRoom(roomId)
 			if !exists {
-				c.SendMessage(NewClientErrorMessage("room not found"))
+				c.clientError("room not found")
+				return
+			}
+			if err := room.Authorize(c, ActionClose, nil); err != nil {
+				c.accessDenied(err)
 				return
 			}
 			room.Close()
 		}
-		for _, handler := range c.handlers.closeRoomHandlers {
-			handler(roomId, rest[36:])
+		for _, handler := range handlers {
+			handler(roomId, rest)
+		}
+	case KickMessage:
+		clientId := frame.ClientId
+		handlers := c.handlers.kickHandlers.take()
+		if len(handlers) == 0 {
+			room, exists := c.GetRoom(roomId)
+			if !exists {
+				c.clientError("room not found")
+				return
+			}
+			target, exists := c.hub.namespace.GetClientById(clientId)
+			if !exists {
+				c.clientError("client not found")
+				return
+			}
+			if err := room.Authorize(c, ActionKick, nil); err != nil {
+				c.accessDenied(err)
+				return
+			}
+			room.Kick(target)
+		}
+		for _, handler := range handlers {
+			handler(roomId, clientId)
 		}
+	case StreamMessage, OpenStreamMessage, CloseStreamMessage:
+		c.readStreamMessage(int(frame.Type), rest)
+	case RequestMessage:
+		c.readRequest(rest)
+	case ResponseMessage:
+		c.readResponse(rest)
+	case EventMessage:
+		c.readEvent(frame.Event, rest)
 	case StatusMessage:
-		_ = rest[0] != 0
+		c.negotiate(rest)
 	default:
-		for _, handler := range c.handlers.binaryHandlers {
-			handler(p)
-		}
+		c.clientError("unexpected signal")
 	}
 }
 
-// Client				Server
-//	 |	   Http Upgrade    |
-//	 |-------------------->|
-//	 |<--------------------|
-//	 |					   |
-//	 |		   Init		   |
-//	 |-------------------->|
-//	 |					   |
-//	 |		  Status	   |
-//	 |<--------------------|
-//	 |					   |
+// Handshake, see handshake.go. Clients which never send Status keep speaking version 1.
+//
+//	Client                              Server
+//	  |           Http Upgrade            |
+//	  |---------------------------------->|
+//	  |<----------------------------------|
+//	  |                                   |
+//	  |  Init (versions, capabilities)    |
+//	  |<----------------------------------|
+//	  |                                   |
+//	  |  Status (version, capabilities)   |
+//	  |---------------------------------->|
+//	  |                                   |
+//	  |  frames in the chosen version     |
+//	  |<--------------------------------->|
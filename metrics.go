@@ -0,0 +1,246 @@
+package axion
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// Labels of received messages, by websocket message type and protocol message type.
+var messageKinds = map[int]string{
+	BroadCastMessage:   "broadcast",
+	RoomMessage:        "room_message",
+	JoinRoomMessage:    "join_room",
+	LeaveRoomMessage:   "leave_room",
+	OpenRoomMessage:    "open_room",
+	CloseRoomMessage:   "close_room",
+	KickMessage:        "kick",
+	StatusMessage:      "status",
+	StreamMessage:      "stream",
+	OpenStreamMessage:  "open_stream",
+	CloseStreamMessage: "close_stream",
+	RequestMessage:     "request",
+	ResponseMessage:    "response",
+	EventMessage:       "event",
+}
+
+var otherMessageKinds = []string{"text", "binary", "close", "ping", "pong", "invalid"}
+
+var disconnectReasons = []string{"connection_lost", "closed", "client_closed", "message_too_big", "kicked", "expired", "timeout", "slow_consumer", "rate_limited", "panic", "shutdown"}
+
+var slowConsumerPolicies = []SlowConsumerPolicy{PolicyBlock, PolicyDropOldest, PolicyDropNewest, PolicyCoalesce, PolicyDisconnect}
+
+func receivedKind(msgType int, frameType uint32, isFrame bool) string {
+	if kind, ok := messageKinds[int(frameType)]; ok && isFrame {
+		return kind
+	}
+	switch msgType {
+	case websocket.TextMessage:
+		return "text"
+	case websocket.BinaryMessage:
+		return "binary"
+	case websocket.CloseMessage:
+		return "close"
+	case websocket.PingMessage:
+		return "ping"
+	case websocket.PongMessage:
+		return "pong"
+	default:
+		return "invalid"
+	}
+}
+
+// Counters of a server, shared by all its namespaces.
+type metrics struct {
+	upgrades         atomic.Uint64
+	upgradesRejected atomic.Uint64
+	messagesSent     atomic.Uint64
+	bytesSent        atomic.Uint64
+	bytesReceived    atomic.Uint64
+	panics           atomic.Uint64
+	received         map[string]*atomic.Uint64
+	dropped          map[SlowConsumerPolicy]*atomic.Uint64
+	disconnects      map[string]*atomic.Uint64
+	rateLimited      map[RateLimitScope]*atomic.Uint64
+	fanout           *histogram
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		received:    make(map[string]*atomic.Uint64),
+		dropped:     make(map[SlowConsumerPolicy]*atomic.Uint64),
+		disconnects: make(map[string]*atomic.Uint64),
+		rateLimited: make(map[RateLimitScope]*atomic.Uint64),
+		fanout:      newHistogram(1, 2, 5, 10, 25, 50, 100, 250, 500, 1000),
+	}
+	for _, kind := range messageKinds {
+		m.received[kind] = new(atomic.Uint64)
+	}
+	for _, kind := range otherMessageKinds {
+		m.received[kind] = new(atomic.Uint64)
+	}
+	for _, policy := range slowConsumerPolicies {
+		m.dropped[policy] = new(atomic.Uint64)
+	}
+	for _, reason := range disconnectReasons {
+		m.disconnects[reason] = new(atomic.Uint64)
+	}
+	for _, scope := range rateLimitScopes {
+		m.rateLimited[scope] = new(atomic.Uint64)
+	}
+	return m
+}
+
+func (m *metrics) messageReceived(kind string, size int) {
+	m.received[kind].Add(1)
+	m.bytesReceived.Add(uint64(size))
+}
+
+type histogram struct {
+	bounds []float64
+	counts []atomic.Uint64
+	sum    atomic.Uint64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]atomic.Uint64, len(bounds)+1),
+	}
+}
+
+func (h *histogram) observe(value int) {
+	index, _ := slices.BinarySearch(h.bounds, float64(value))
+	h.counts[index].Add(1)
+	h.sum.Add(uint64(value))
+}
+
+func (h *histogram) write(w io.Writer, name string) {
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i].Load()
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, cumulative)
+	}
+	cumulative += h.counts[len(h.bounds)].Load()
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, cumulative)
+	fmt.Fprintf(w, "%s_sum %d\n", name, h.sum.Load())
+	fmt.Fprintf(w, "%s_count %d\n", name, cumulative)
+}
+
+// Message and byte counters of a client and the number of its messages dropped by rate limits.
+type ClientStats struct {
+	MessagesReceived uint64
+	MessagesSent     uint64
+	BytesReceived    uint64
+	BytesSent        uint64
+	RateLimited      uint64
+}
+
+type clientStats struct {
+	messagesReceived atomic.Uint64
+	messagesSent     atomic.Uint64
+	bytesReceived    atomic.Uint64
+	bytesSent        atomic.Uint64
+	rateLimited      atomic.Uint64
+}
+
+// Returns the message and byte counters of the client's connection.
+func (c *Client) Stats() ClientStats {
+	stats := &c.Connection().stats
+	return ClientStats{
+		MessagesReceived: stats.messagesReceived.Load(),
+		MessagesSent:     stats.messagesSent.Load(),
+		BytesReceived:    stats.bytesReceived.Load(),
+		BytesSent:        stats.bytesSent.Load(),
+		RateLimited:      stats.rateLimited.Load(),
+	}
+}
+
+// Returns a handler serving the metrics of the server in the Prometheus text exposition format.
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s.WriteMetrics(w)
+	})
+}
+
+// Writes the metrics of the server in the Prometheus text exposition format.
+func (s *Server) WriteMetrics(w io.Writer) {
+	m := s.metrics
+
+	var clients, detached, queued, rooms int
+	s.mu.Lock()
+	namespaces := make([]*Namespace, 0, len(s.namespaces))
+	for _, ns := range s.namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	s.mu.Unlock()
+	for _, ns := range namespaces {
+		rooms += len(ns.hub.getRooms())
+	}
+	for _, client := range s.hub.getClients() {
+		clients++
+		if client.Detached() {
+			detached++
+		}
+		queued += client.QueueLen()
+	}
+
+	gauge(w, "axion_clients", "Number of clients, including detached ones.", clients)
+	gauge(w, "axion_clients_detached", "Number of clients waiting for a reconnect.", detached)
+	gauge(w, "axion_rooms", "Number of rooms in all namespaces.", rooms)
+	gauge(w, "axion_send_queue_messages", "Number of messages waiting in send queues.", queued)
+
+	counter(w, "axion_upgrades_total", "Accepted websocket upgrades.", m.upgrades.Load())
+	counter(w, "axion_upgrades_rejected_total", "Rejected or failed websocket upgrades.", m.upgradesRejected.Load())
+	counter(w, "axion_messages_sent_total", "Messages written to connections.", m.messagesSent.Load())
+	counter(w, "axion_bytes_sent_total", "Bytes written to connections.", m.bytesSent.Load())
+	counter(w, "axion_bytes_received_total", "Bytes received from connections.", m.bytesReceived.Load())
+	counter(w, "axion_handler_panics_total", "Panics recovered in handlers.", m.panics.Load())
+
+	header(w, "axion_messages_received_total", "Messages received by type.", "counter")
+	kinds := make([]string, 0, len(m.received))
+	for kind := range m.received {
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "axion_messages_received_total{type=%q} %d\n", kind, m.received[kind].Load())
+	}
+
+	header(w, "axion_messages_dropped_total", "Messages dropped by slow consumer policy.", "counter")
+	for _, policy := range slowConsumerPolicies {
+		fmt.Fprintf(w, "axion_messages_dropped_total{policy=%q} %d\n", policy.String(), m.dropped[policy].Load())
+	}
+
+	header(w, "axion_disconnects_total", "Ended client sessions by reason.", "counter")
+	for _, reason := range disconnectReasons {
+		fmt.Fprintf(w, "axion_disconnects_total{reason=%q} %d\n", reason, m.disconnects[reason].Load())
+	}
+
+	header(w, "axion_messages_rate_limited_total", "Messages exceeding a rate limit by scope.", "counter")
+	for _, scope := range rateLimitScopes {
+		fmt.Fprintf(w, "axion_messages_rate_limited_total{scope=%q} %d\n", scope, m.rateLimited[scope].Load())
+	}
+
+	header(w, "axion_broadcast_fanout", "Number of recipients per broadcast.", "histogram")
+	m.fanout.write(w, "axion_broadcast_fanout")
+}
+
+func header(w io.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func gauge(w io.Writer, name string, help string, value int) {
+	header(w, name, help, "gauge")
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+func counter(w io.Writer, name string, help string, value uint64) {
+	header(w, name, help, "counter")
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
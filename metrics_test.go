@@ -0,0 +1,103 @@
+package axion
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Returns the value of the sample with the given name and labels, as written by WriteMetrics.
+func metricValue(s *Server, sample string) (float64, bool) {
+	var b strings.Builder
+	s.WriteMetrics(&b)
+	scanner := bufio.NewScanner(strings.NewReader(b.String()))
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), " ")
+		if ok && name == sample {
+			v, err := strconv.ParseFloat(value, 64)
+			return v, err == nil
+		}
+	}
+	return 0, false
+}
+
+// Waits until the sample has the wanted value.
+func expectMetric(t *testing.T, s *Server, sample string, want float64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	got, ok := metricValue(s, sample)
+	for ; (!ok || got != want) && time.Now().Before(deadline); got, ok = metricValue(s, sample) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !ok {
+		t.Errorf("no sample %s", sample)
+	} else if got != want {
+		t.Errorf("%s = %g, want %g", sample, got, want)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	s := NewServer(WithSendQueue(SendQueueOptions{
+		Size:        1,
+		Policy:      PolicyDisconnect,
+		CloseCode:   websocket.CloseTryAgainLater,
+		CloseReason: "slow consumer",
+	}))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	dial := func() (*websocket.Conn, string) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := readInit(t, conn)
+		return conn, id
+	}
+	slow, slowId := dial()
+	defer slow.Close()
+	conn, _ := dial()
+	s.CreateRoom()
+
+	expectMetric(t, s, "axion_clients", 2)
+	expectMetric(t, s, "axion_rooms", 1)
+	expectMetric(t, s, "axion_upgrades_total", 2)
+
+	conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+	broadcast := binary.BigEndian.AppendUint32(nil, BroadCastMessage)
+	conn.WriteMessage(websocket.BinaryMessage, append(broadcast, "news"...))
+	expectMetric(t, s, `axion_messages_received_total{type="text"}`, 1)
+	expectMetric(t, s, `axion_messages_received_total{type="broadcast"}`, 1)
+	expectMetric(t, s, `axion_broadcast_fanout_bucket{le="1"}`, 0)
+	expectMetric(t, s, `axion_broadcast_fanout_bucket{le="2"}`, 1)
+	expectMetric(t, s, `axion_broadcast_fanout_bucket{le="+Inf"}`, 1)
+	expectMetric(t, s, "axion_broadcast_fanout_sum", 2)
+
+	// The slow client never reads, so its socket buffers and then its queue fill up.
+	client, ok := s.GetClientById(slowId)
+	if !ok {
+		t.Fatal("slow client not registered")
+	}
+	payload := make([]byte, 1<<20)
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		if v, _ := metricValue(s, `axion_messages_dropped_total{policy="disconnect"}`); v > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("slow consumer never dropped")
+		}
+		client.SendMessage(NewBinaryMessage(payload))
+	}
+	expectMetric(t, s, `axion_disconnects_total{reason="slow_consumer"}`, 1)
+
+	conn.Close()
+	expectMetric(t, s, `axion_disconnects_total{reason="connection_lost"}`, 1)
+	expectMetric(t, s, "axion_clients", 0)
+	expectMetric(t, s, `axion_messages_dropped_total{policy="drop_newest"}`, 0)
+}
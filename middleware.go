@@ -0,0 +1,53 @@
+package axion
+
+// An inbound message on its way to the handlers. Protocol frames carry their type and fields, text and binary
+// messages of the application have Type 0 and their content as Payload.
+type Message struct {
+	Client *Client
+	// Websocket message type.
+	MsgType int
+	Frame
+}
+
+// Returns the label of the message as used by the metrics, e.g. "text", "binary", "join_room" or "event".
+func (m *Message) Kind() string {
+	return receivedKind(m.MsgType, m.Type, m.Type != 0)
+}
+
+// Dispatches an inbound message.
+type MessageHandler func(m *Message)
+
+// Wraps the dispatch of inbound messages. A middleware can inspect the message, rewrite it before calling next
+// or drop it by not calling next at all.
+type Middleware func(next MessageHandler) MessageHandler
+
+// Installs middleware around the dispatch of every inbound message of every client, including the clients of
+// streams. Middleware of the server runs before the middleware of the client, in the order it was installed.
+// Middleware can be installed at any time, it applies from the next message on.
+func (s *Server) Use(middleware ...Middleware) {
+	for _, m := range middleware {
+		s.middleware.add(m, false)
+	}
+}
+
+// Installs middleware around the dispatch of inbound messages of the client. It runs after the middleware
+// of the server, in the order it was installed.
+func (c *Client) Use(middleware ...Middleware) {
+	for _, m := range middleware {
+		c.middleware.add(m, false)
+	}
+}
+
+// Runs the message through the middleware of the server and the client and dispatches it.
+func (c *Client) dispatch(m *Message) {
+	next := MessageHandler(c.handleInbound)
+	client := c.middleware.take()
+	for i := len(client) - 1; i >= 0; i-- {
+		next = client[i](next)
+	}
+	server := c.hub.server.middleware.take()
+	for i := len(server) - 1; i >= 0; i-- {
+		next = server[i](next)
+	}
+	next(m)
+}
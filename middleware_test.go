@@ -0,0 +1,116 @@
+package axion
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestMiddleware(t *testing.T) {
+	s := NewServer()
+	s.CreateRoomWithId("lobby")
+
+	var mu sync.Mutex
+	var kinds []string
+	s.Use(func(next MessageHandler) MessageHandler {
+		return func(m *Message) {
+			mu.Lock()
+			kinds = append(kinds, m.Kind())
+			mu.Unlock()
+			next(m)
+		}
+	})
+	s.HandleConnect(func(client *Client, r *http.Request) {
+		client.Use(func(next MessageHandler) MessageHandler {
+			return func(m *Message) {
+				switch m.Type {
+				case LeaveRoomMessage:
+					return
+				case RoomMessage:
+					m.Payload = bytes.ToUpper(m.Payload)
+				}
+				next(m)
+			}
+		})
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	header := map[string][]string{"Sec-WebSocket-Protocol": {"axion.json"}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.ReadMessage()
+
+	for _, message := range []string{
+		`{"type":"join_room","room":"lobby"}`,
+		`{"type":"leave_room","room":"lobby"}`,
+		`{"type":"room_message","room":"lobby","data":"hello"}`,
+	} {
+		conn.WriteMessage(websocket.TextMessage, []byte(message))
+	}
+
+	_, p, _ := conn.ReadMessage()
+	if !strings.Contains(string(p), "client_joined") {
+		t.Fatalf("expected join signal, got %s", p)
+	}
+	// The leave was dropped, so the client still receives the rewritten room message.
+	_, p, _ = conn.ReadMessage()
+	if string(p) != "HELLO" {
+		t.Errorf("got %q, want HELLO", p)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(kinds, ",") != "join_room,leave_room,room_message" {
+		t.Errorf("got kinds %v", kinds)
+	}
+}
+
+func TestUseWhileDispatching(t *testing.T) {
+	s := NewServer()
+	noop := func(next MessageHandler) MessageHandler { return next }
+	clients := make(chan *Client, 1)
+	s.HandleConnect(func(client *Client, r *http.Request) {
+		client.HandleText(func(message string) {
+			client.SendMessage(NewMessage(websocket.TextMessage, []byte(message)))
+		})
+		clients <- client
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.ReadMessage()
+	client := <-clients
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			s.Use(noop)
+			client.Use(noop)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		conn.WriteMessage(websocket.TextMessage, []byte("hi"))
+	}
+	for i := 0; i < 100; i++ {
+		if _, p, err := conn.ReadMessage(); err != nil || string(p) != "hi" {
+			t.Fatalf("got %q, %v", p, err)
+		}
+	}
+	wg.Wait()
+}
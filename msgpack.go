@@ -0,0 +1,195 @@
+package axion
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+)
+
+// Carries frames as MessagePack maps in binary messages, subprotocol "axion.msgpack". The keys are the ones of
+// JSONCodec, "type", "room", "client" and "event" are strings and "data" holds the payload as bin (str is
+// accepted as well). Unused keys are omitted, unknown keys with scalar values are ignored.
+type MessagePackCodec struct{}
+
+func (MessagePackCodec) Name() string {
+	return "axion.msgpack"
+}
+
+func (MessagePackCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (MessagePackCodec) Encode(f Frame) ([]byte, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	fields := 1
+	for _, set := range []bool{f.RoomId != "", f.ClientId != "", f.Event != "", len(f.Payload) > 0} {
+		if set {
+			fields++
+		}
+	}
+
+	p := make([]byte, 0, 40+len(f.RoomId)+len(f.ClientId)+len(f.Event)+len(f.Payload))
+	p = append(p, 0x80|byte(fields))
+	p = appendMsgpackString(p, "type")
+	p = appendMsgpackString(p, frameNames[f.Type])
+	if f.RoomId != "" {
+		p = appendMsgpackString(p, "room")
+		p = appendMsgpackString(p, f.RoomId)
+	}
+	if f.ClientId != "" {
+		p = appendMsgpackString(p, "client")
+		p = appendMsgpackString(p, f.ClientId)
+	}
+	if f.Event != "" {
+		p = appendMsgpackString(p, "event")
+		p = appendMsgpackString(p, f.Event)
+	}
+	if len(f.Payload) > 0 {
+		p = appendMsgpackString(p, "data")
+		p = appendMsgpackBinary(p, f.Payload)
+	}
+	return p, nil
+}
+
+func (MessagePackCodec) Decode(p []byte) (Frame, error) {
+	size, p, ok := msgpackMapHeader(p)
+	if !ok {
+		return Frame{}, ErrUnknownFrame
+	}
+
+	var f Frame
+	var kind string
+	var err error
+	for i := 0; i < size && err == nil; i++ {
+		var key, value []byte
+		if key, p, err = msgpackValue(p); err != nil {
+			break
+		}
+		if value, p, err = msgpackValue(p); err != nil {
+			break
+		}
+		switch string(key) {
+		case "type":
+			kind = string(value)
+		case "room":
+			f.RoomId = string(value)
+		case "client":
+			f.ClientId = string(value)
+		case "event":
+			f.Event = string(value)
+		case "data":
+			f.Payload = value
+		}
+	}
+
+	var known bool
+	if f.Type, known = frameTypes[kind]; !known {
+		return Frame{}, ErrUnknownFrame
+	}
+	if err != nil {
+		return Frame{}, err
+	}
+	if err := f.validate(); err != nil {
+		return Frame{}, err
+	}
+	return f, nil
+}
+
+func appendMsgpackString(p []byte, s string) []byte {
+	switch {
+	case len(s) < 32:
+		p = append(p, 0xa0|byte(len(s)))
+	case len(s) <= 0xFF:
+		p = append(p, 0xd9, byte(len(s)))
+	case len(s) <= 0xFFFF:
+		p = binary.BigEndian.AppendUint16(append(p, 0xda), uint16(len(s)))
+	default:
+		p = binary.BigEndian.AppendUint32(append(p, 0xdb), uint32(len(s)))
+	}
+	return append(p, s...)
+}
+
+func appendMsgpackBinary(p []byte, b []byte) []byte {
+	switch {
+	case len(b) <= 0xFF:
+		p = append(p, 0xc4, byte(len(b)))
+	case len(b) <= 0xFFFF:
+		p = binary.BigEndian.AppendUint16(append(p, 0xc5), uint16(len(b)))
+	default:
+		p = binary.BigEndian.AppendUint32(append(p, 0xc6), uint32(len(b)))
+	}
+	return append(p, b...)
+}
+
+// Reads the header of a map. Reports false if p does not start with a map.
+func msgpackMapHeader(p []byte) (int, []byte, bool) {
+	if len(p) == 0 {
+		return 0, nil, false
+	}
+	switch b := p[0]; {
+	case b >= 0x80 && b <= 0x8f:
+		return int(b & 0x0f), p[1:], true
+	case b == 0xde && len(p) >= 3:
+		return int(binary.BigEndian.Uint16(p[1:])), p[3:], true
+	case b == 0xdf && len(p) >= 5:
+		return int(binary.BigEndian.Uint32(p[1:])), p[5:], true
+	}
+	return 0, nil, false
+}
+
+// Reads a value. Returns the bytes of strings and binaries, other scalars are skipped and return nil.
+func msgpackValue(p []byte) ([]byte, []byte, error) {
+	if len(p) == 0 {
+		return nil, nil, ErrShortFrame
+	}
+	b, p := p[0], p[1:]
+
+	var lengthSize, skip int
+	switch {
+	case b >= 0xa0 && b <= 0xbf:
+		return msgpackBytes(p, int(b&0x1f))
+	case b == 0xd9 || b == 0xc4:
+		lengthSize = 1
+	case b == 0xda || b == 0xc5:
+		lengthSize = 2
+	case b == 0xdb || b == 0xc6:
+		lengthSize = 4
+	case b <= 0x7f || b >= 0xe0 || b == 0xc0 || b == 0xc2 || b == 0xc3:
+		return nil, p, nil
+	case b == 0xcc || b == 0xd0:
+		skip = 1
+	case b == 0xcd || b == 0xd1:
+		skip = 2
+	case b == 0xce || b == 0xd2 || b == 0xca:
+		skip = 4
+	case b == 0xcf || b == 0xd3 || b == 0xcb:
+		skip = 8
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported MessagePack type %#x", ErrInvalidField, b)
+	}
+
+	if skip > 0 {
+		if len(p) < skip {
+			return nil, nil, ErrShortFrame
+		}
+		return nil, p[skip:], nil
+	}
+	if len(p) < lengthSize {
+		return nil, nil, ErrShortFrame
+	}
+	var length int
+	for _, b := range p[:lengthSize] {
+		length = length<<8 | int(b)
+	}
+	return msgpackBytes(p[lengthSize:], length)
+}
+
+func msgpackBytes(p []byte, length int) ([]byte, []byte, error) {
+	if length < 0 || len(p) < length {
+		return nil, nil, ErrShortFrame
+	}
+	return p[:length], p[length:], nil
+}
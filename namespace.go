@@ -0,0 +1,93 @@
+package axion
+
+import "net/http"
+
+// Name of the namespace served by the connections themselves.
+const DefaultNamespace = "/"
+
+type NamespaceHandlers struct {
+	connectHandler   func(client *Client, r *http.Request)
+	authorizeHandler func(client *Client, r *http.Request) error
+	eventHandlers    handlerMap[eventHandler]
+}
+
+// A logical channel with its own clients, rooms and handlers. Clients open a namespace as a stream on their
+// connection. The client of a stream shares its id and upgrade request with the client of the connection.
+type Namespace struct {
+	name       string
+	hub        *Hub
+	handlers   *NamespaceHandlers
+	roomPolicy RoomPolicy
+}
+
+func newNamespace(name string, server *Server) *Namespace {
+	ns := &Namespace{
+		name:     name,
+		handlers: new(NamespaceHandlers),
+	}
+	ns.handlers.connectHandler = func(client *Client, r *http.Request) {}
+	ns.handlers.authorizeHandler = func(client *Client, r *http.Request) error { return nil }
+
+	ns.hub = newHub(server, ns)
+	go ns.hub.run()
+	return ns
+}
+
+// Returns the name of the namespace.
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// Returns all clients which opened the namespace.
+func (ns *Namespace) Clients() []*Client {
+	return ns.hub.getClients()
+}
+
+// Returns all rooms of the namespace.
+func (ns *Namespace) Rooms() []*Room {
+	return ns.hub.getRooms()
+}
+
+// Reports whether the client with specified id opened the namespace and returns it if existing.
+func (ns *Namespace) GetClientById(id string) (*Client, bool) {
+	client := ns.hub.getClientById(id)
+	return client, client != nil
+}
+
+// Reports whether the room with specified id exists in the namespace and returns it if existing.
+func (ns *Namespace) GetRoomById(id string) (*Room, bool) {
+	room := ns.hub.getRoomById(id)
+	return room, room != nil
+}
+
+// Broadcasts a message with the given websocket message type and content to all clients of the namespace.
+func (ns *Namespace) Broadcast(msgType int, content []byte) {
+	ns.hub.broadcastMessage(NewMessage(msgType, content))
+}
+
+// Broadcasts a message to all clients of the namespace.
+func (ns *Namespace) BroadcastMessage(message WsMessage) {
+	ns.hub.broadcastMessage(message)
+}
+
+// Creates and returns a new empty room in the namespace.
+func (ns *Namespace) CreateRoom() *Room {
+	return ns.hub.createRoom()
+}
+
+// Creates a room with the given id in the namespace. Reports false if the id is taken.
+// Clients speaking protocol version 1 can only address rooms with uuids as id.
+func (ns *Namespace) CreateRoomWithId(id string) (*Room, bool) {
+	return ns.hub.createRoomWithId(id)
+}
+
+// Handles clients opening the namespace. The client passed is the client of the stream.
+func (ns *Namespace) HandleConnect(fun func(client *Client, r *http.Request)) {
+	ns.handlers.connectHandler = fun
+}
+
+// Decides whether a connection may open the namespace. The client passed is the client of the connection,
+// returning an error rejects the stream with the error message.
+func (ns *Namespace) HandleAuthorize(fun func(client *Client, r *http.Request) error) {
+	ns.handlers.authorizeHandler = fun
+}
@@ -0,0 +1,99 @@
+package axion
+
+import (
+	"errors"
+	"runtime/debug"
+
+	"github.com/gorilla/websocket"
+)
+
+// What happens to the connection of a client whose handler panicked.
+type PanicPolicy int
+
+const (
+	// Closes the connection with the configured close code and ends the session of the client.
+	// A panic in a handler of a stream closes only the stream.
+	PanicClose PanicPolicy = iota
+	// Keeps the connection open, the message which caused the panic is lost.
+	PanicKeep
+)
+
+// Configures how panics of handlers are recovered.
+type PanicOptions struct {
+	// Defaults to PanicClose.
+	Policy PanicPolicy
+	// Sends a ServerError with the text "internal error" to the client.
+	NotifyClient bool
+	// Close frame sent with PanicClose. Defaults to 1011 (internal error).
+	CloseCode   int
+	CloseReason string
+}
+
+var defaultPanicOptions = PanicOptions{
+	Policy:      PanicClose,
+	CloseCode:   websocket.CloseInternalServerErr,
+	CloseReason: "internal error",
+}
+
+// Returned to the client as error of a request whose handler panicked.
+var errInternal = errors.New("internal error")
+
+// Configures the recovery of panics in handlers. Handlers always run under recovery, by default a panic
+// closes the connection with 1011 (internal error) without notifying the client.
+func WithPanicRecovery(opts PanicOptions) Option {
+	return func(o *serverOptions) {
+		if opts.CloseCode == 0 {
+			opts.CloseCode = defaultPanicOptions.CloseCode
+			opts.CloseReason = defaultPanicOptions.CloseReason
+		}
+		o.panics = opts
+	}
+}
+
+// Triggered when a handler panicked, after the panic was logged and before the policy is applied.
+// handler names the kind of handler, e.g. "message", "connect" or "disconnect".
+func (s *Server) HandlePanic(fun func(client *Client, handler string, value any, stack []byte)) {
+	s.handlers.panicHandler = fun
+}
+
+// Runs a handler of the client and recovers from its panic. Reports whether the handler returned normally.
+func (c *Client) protect(handler string, fun func()) (ok bool) {
+	defer func() {
+		if value := recover(); value != nil {
+			c.recovered(handler, value, debug.Stack())
+			ok = false
+		}
+	}()
+	fun()
+	return true
+}
+
+func (c *Client) recovered(handler string, value any, stack []byte) {
+	s := c.hub.server
+	c.logger.Error("handler panicked", "handler", handler, "panic", value, "stack", string(stack))
+	s.metrics.panics.Add(1)
+	s.reportPanic(c, handler, value, stack)
+
+	opts := &s.options.panics
+	if opts.NotifyClient {
+		c.SendMessage(NewServerErrorMessage("internal error"))
+	}
+	if opts.Policy != PanicClose {
+		return
+	}
+	if c.parent != nil {
+		c.parent.closeStream(c, CloseStatus{Code: opts.CloseCode, Reason: opts.CloseReason, Initiator: InitiatorServer})
+		return
+	}
+	c.terminate(opts.CloseCode, opts.CloseReason, "panic")
+}
+
+// Calls the panic handler, which may not take down the process either.
+func (s *Server) reportPanic(c *Client, handler string, value any, stack []byte) {
+	defer func() {
+		if value := recover(); value != nil {
+			c.logger.Error("panic handler panicked", "panic", value)
+		}
+	}()
+	s.handlers.panicHandler(c, handler, value, stack)
+}
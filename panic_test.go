@@ -0,0 +1,72 @@
+package axion
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func dialPanicServer(t *testing.T, opts PanicOptions, hook func(client *Client, handler string, value any, stack []byte)) *websocket.Conn {
+	s := NewServer(WithPanicRecovery(opts))
+	s.HandlePanic(hook)
+	s.HandleConnect(func(client *Client, r *http.Request) {
+		client.HandleText(func(message string) {
+			if message == "panic" {
+				panic("boom")
+			}
+			client.SendMessage(NewMessage(websocket.TextMessage, []byte("echo "+message)))
+		})
+	})
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	header := map[string][]string{"Sec-WebSocket-Protocol": {"axion.json"}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.ReadMessage()
+	return conn
+}
+
+func TestPanicKeep(t *testing.T) {
+	reported := make(chan string, 1)
+	conn := dialPanicServer(t, PanicOptions{Policy: PanicKeep, NotifyClient: true}, func(client *Client, handler string, value any, stack []byte) {
+		if len(stack) == 0 {
+			t.Error("missing stack")
+		}
+		reported <- handler + ": " + value.(string)
+	})
+
+	conn.WriteMessage(websocket.TextMessage, []byte("panic"))
+	conn.WriteMessage(websocket.TextMessage, []byte("hi"))
+
+	_, p, _ := conn.ReadMessage()
+	if string(p) != `{"type":"server_error","data":"internal error"}` {
+		t.Errorf("got %s, want server error", p)
+	}
+	_, p, _ = conn.ReadMessage()
+	if string(p) != "echo hi" {
+		t.Errorf("got %q, want echo hi", p)
+	}
+	if got := <-reported; got != "message: boom" {
+		t.Errorf("reported %q", got)
+	}
+}
+
+func TestPanicClose(t *testing.T) {
+	conn := dialPanicServer(t, PanicOptions{}, func(client *Client, handler string, value any, stack []byte) {})
+
+	conn.WriteMessage(websocket.TextMessage, []byte("panic"))
+
+	_, _, err := conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseInternalServerErr {
+		t.Errorf("got %v, want close 1011", err)
+	}
+}
@@ -0,0 +1,207 @@
+package axion
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Decides what happens to a message sent to a client whose send queue is full.
+type SlowConsumerPolicy int
+
+const (
+	// Waits up to the block timeout for free space and drops the new message afterwards.
+	PolicyBlock SlowConsumerPolicy = iota
+	// Drops the oldest queued message to make room for the new one.
+	PolicyDropOldest
+	// Drops the new message.
+	PolicyDropNewest
+	// Replaces the queued message with the same coalesce key as the new one. Drops the oldest queued message if there is none.
+	PolicyCoalesce
+	// Discards the queued messages, closes the connection with the configured close code and ends the session of the client.
+	PolicyDisconnect
+)
+
+func (p SlowConsumerPolicy) String() string {
+	switch p {
+	case PolicyBlock:
+		return "block"
+	case PolicyDropOldest:
+		return "drop_oldest"
+	case PolicyDropNewest:
+		return "drop_newest"
+	case PolicyCoalesce:
+		return "coalesce"
+	case PolicyDisconnect:
+		return "disconnect"
+	default:
+		return "unknown"
+	}
+}
+
+type SendQueueOptions struct {
+	// Maximum number of messages waiting to be written to a client.
+	Size int
+	// Applied when a message is sent to a client whose queue is full.
+	Policy SlowConsumerPolicy
+	// Maximum time PolicyBlock waits for free space. Zero waits until the client is closed.
+	BlockTimeout time.Duration
+	// Identifies messages which supersede each other for PolicyCoalesce. Messages with an empty key are never coalesced.
+	CoalesceKey func(message WsMessage) string
+	// Close code and reason used by PolicyDisconnect.
+	CloseCode   int
+	CloseReason string
+}
+
+// Configures the per client send queue. Defaults to 256 messages and PolicyDisconnect with close code 1013 (try again later).
+// A Size of zero or less keeps the default size, an unknown Policy falls back to PolicyDisconnect.
+func WithSendQueue(opts SendQueueOptions) Option {
+	return func(o *serverOptions) {
+		if !slices.Contains(slowConsumerPolicies, opts.Policy) {
+			opts.Policy = defaultSendQueueOptions.Policy
+		}
+		if opts.Size <= 0 {
+			opts.Size = defaultSendQueueOptions.Size
+		}
+		if opts.CloseCode == 0 {
+			opts.CloseCode = defaultSendQueueOptions.CloseCode
+			opts.CloseReason = defaultSendQueueOptions.CloseReason
+		}
+		o.sendQueue = opts
+	}
+}
+
+var defaultSendQueueOptions = SendQueueOptions{
+	Size:        256,
+	Policy:      PolicyDisconnect,
+	CloseCode:   websocket.CloseTryAgainLater,
+	CloseReason: "slow consumer",
+}
+
+// Bounded queue of messages waiting for the write pump.
+type sendQueue struct {
+	messages []WsMessage
+	ready    chan struct{}
+	space    chan struct{}
+	closed   bool
+	mu       sync.Mutex
+}
+
+func newSendQueue() *sendQueue {
+	return &sendQueue{
+		ready: make(chan struct{}, 1),
+		space: make(chan struct{}),
+	}
+}
+
+func (q *sendQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Appends the message applying the policy if the queue is full. Returns the message which got dropped,
+// if any, and whether the client has to be disconnected.
+func (q *sendQueue) push(message WsMessage, opts *SendQueueOptions) (dropped *WsMessage, disconnect bool) {
+	var deadline <-chan time.Time
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return nil, false
+		}
+		if len(q.messages) < opts.Size {
+			q.messages = append(q.messages, message)
+			q.mu.Unlock()
+			q.signal()
+			return nil, false
+		}
+
+		switch opts.Policy {
+		case PolicyBlock:
+			space := q.space
+			q.mu.Unlock()
+			if opts.BlockTimeout > 0 && deadline == nil {
+				timer := time.NewTimer(opts.BlockTimeout)
+				defer timer.Stop()
+				deadline = timer.C
+			}
+			select {
+			case <-space:
+				continue
+			case <-deadline:
+				return &message, false
+			}
+		case PolicyDropOldest:
+			old := q.messages[0]
+			dropped = &old
+			q.messages = append(q.messages[1:], message)
+		case PolicyDropNewest:
+			dropped = &message
+		case PolicyCoalesce:
+			index := 0
+			if key := opts.keyOf(message); key != "" {
+				for i, queued := range q.messages {
+					if opts.keyOf(queued) == key {
+						index = i
+						break
+					}
+				}
+			}
+			old := q.messages[index]
+			dropped = &old
+			q.messages = append(q.messages[:index], q.messages[index+1:]...)
+			q.messages = append(q.messages, message)
+		case PolicyDisconnect:
+			q.messages = nil
+			q.closeLocked()
+			dropped, disconnect = &message, true
+		}
+		q.mu.Unlock()
+		return dropped, disconnect
+	}
+}
+
+func (opts *SendQueueOptions) keyOf(message WsMessage) string {
+	if opts.CoalesceKey == nil {
+		return ""
+	}
+	return opts.CoalesceKey(message)
+}
+
+// Takes all queued messages. Reports whether the queue has been closed.
+func (q *sendQueue) drain() ([]WsMessage, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	messages := q.messages
+	q.messages = nil
+	close(q.space)
+	q.space = make(chan struct{})
+	return messages, q.closed
+}
+
+func (q *sendQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.messages)
+}
+
+// Rejects further messages. The write pump flushes the queued ones and stops.
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closeLocked()
+}
+
+func (q *sendQueue) closeLocked() {
+	if q.closed {
+		return
+	}
+	q.closed = true
+	close(q.space)
+	q.space = make(chan struct{})
+	q.signal()
+}
@@ -0,0 +1,148 @@
+package axion
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func queuedContents(q *sendQueue) string {
+	messages, _ := q.drain()
+	var s string
+	for _, message := range messages {
+		s += string(message.content)
+	}
+	return s
+}
+
+func TestSendQueuePolicies(t *testing.T) {
+	key := func(message WsMessage) string { return string(message.content[:1]) }
+	tests := []struct {
+		policy     SlowConsumerPolicy
+		want       string
+		dropped    string
+		disconnect bool
+	}{
+		{PolicyDropOldest, "bc", "a", false},
+		{PolicyDropNewest, "ab", "c", false},
+		{PolicyCoalesce, "ba", "a", false},
+		{PolicyDisconnect, "", "c", true},
+		{PolicyBlock, "ab", "c", false},
+	}
+	for _, test := range tests {
+		opts := &SendQueueOptions{Size: 2, Policy: test.policy, BlockTimeout: 10 * time.Millisecond, CoalesceKey: key}
+		q := newSendQueue()
+		q.push(NewTextMesssage("a"), opts)
+		q.push(NewTextMesssage("b"), opts)
+
+		next := "c"
+		if test.policy == PolicyCoalesce {
+			next = "a"
+		}
+		dropped, disconnect := q.push(NewTextMesssage(next), opts)
+		if dropped == nil || string(dropped.content) != test.dropped || disconnect != test.disconnect {
+			t.Errorf("policy %d: dropped %v, disconnect %v", test.policy, dropped, disconnect)
+		}
+		if got := queuedContents(q); got != test.want {
+			t.Errorf("policy %d: queued %q, want %q", test.policy, got, test.want)
+		}
+	}
+}
+
+func TestSendQueueBlockWaitsForSpace(t *testing.T) {
+	opts := &SendQueueOptions{Size: 1, Policy: PolicyBlock}
+	q := newSendQueue()
+	q.push(NewTextMesssage("a"), opts)
+
+	done := make(chan struct{})
+	go func() {
+		q.push(NewTextMesssage("b"), opts)
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if got := queuedContents(q); got != "a" {
+		t.Fatalf("queued %q, want %q", got, "a")
+	}
+	<-done
+	if got := queuedContents(q); got != "b" {
+		t.Fatalf("queued %q, want %q", got, "b")
+	}
+}
+
+func TestSendQueueDefaultSize(t *testing.T) {
+	for _, policy := range slowConsumerPolicies {
+		o := new(serverOptions)
+		WithSendQueue(SendQueueOptions{Policy: policy})(o)
+		if o.sendQueue.Size != defaultSendQueueOptions.Size || o.sendQueue.CloseCode != defaultSendQueueOptions.CloseCode {
+			t.Errorf("policy %s: size %d, close code %d", policy, o.sendQueue.Size, o.sendQueue.CloseCode)
+		}
+
+		q := newSendQueue()
+		if dropped, disconnect := q.push(NewTextMesssage("a"), &o.sendQueue); dropped != nil || disconnect {
+			t.Errorf("policy %s: dropped %v, disconnect %v", policy, dropped, disconnect)
+		}
+		if got := queuedContents(q); got != "a" {
+			t.Errorf("policy %s: queued %q, want a", policy, got)
+		}
+	}
+}
+
+func TestBroadcastDoesNotStallHub(t *testing.T) {
+	s := NewServer(WithSendQueue(SendQueueOptions{Size: 1, Policy: PolicyBlock}))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	// A client which never drains its queue blocks every broadcast after the first.
+	stalled := newClient(s.hub, nil, "stalled")
+	s.hub.mu.Lock()
+	s.hub.clients[stalled.id] = stalled
+	s.hub.mu.Unlock()
+	s.Broadcast(websocket.TextMessage, []byte("fills the queue"))
+	blocked := make(chan struct{})
+	go func() {
+		s.Broadcast(websocket.TextMessage, []byte("blocks"))
+		close(blocked)
+	}()
+
+	// The hub still registers new connections meanwhile.
+	connected := make(chan *websocket.Conn, 1)
+	go func() {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+		if err != nil {
+			connected <- nil
+			return
+		}
+		// The Init message is written once the hub registered the client.
+		conn.ReadMessage()
+		connected <- conn
+	}()
+	select {
+	case conn := <-connected:
+		if conn == nil {
+			t.Fatal("dial failed")
+		}
+		conn.Close()
+	case <-time.After(2 * time.Second):
+		t.Fatal("hub stalled by a blocking broadcast")
+	}
+
+	stalled.queue.close()
+	select {
+	case <-blocked:
+	case <-time.After(2 * time.Second):
+		t.Error("broadcast still blocked after the queue was closed")
+	}
+}
+
+func TestSendQueueUnknownPolicy(t *testing.T) {
+	for _, policy := range []SlowConsumerPolicy{-1, PolicyDisconnect + 1} {
+		o := new(serverOptions)
+		WithSendQueue(SendQueueOptions{Size: 1, Policy: policy})(o)
+		if o.sendQueue.Policy != defaultSendQueueOptions.Policy {
+			t.Errorf("policy %d: got %s, want %s", policy, o.sendQueue.Policy, defaultSendQueueOptions.Policy)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package axion
+
+import (
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// A token bucket refilled with Rate tokens per second holding at most Burst tokens. Every inbound message
+// takes a token. A zero Rate means unlimited, a zero Burst defaults to the tokens of one second, at least one.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l RateLimit) withDefaults() RateLimit {
+	if l.Burst == 0 && l.Rate > 0 {
+		l.Burst = max(1, int(math.Ceil(l.Rate)))
+	}
+	return l
+}
+
+// What happens to a message exceeding a rate limit.
+type RateLimitAction int
+
+const (
+	// Drops the message silently.
+	LimitDrop RateLimitAction = iota
+	// Drops the message and sends a ClientError.
+	LimitError
+	// Drops the message and closes the connection.
+	LimitDisconnect
+)
+
+// The limit a message exceeded.
+type RateLimitScope string
+
+const (
+	ScopeClient RateLimitScope = "client"
+	ScopeKind   RateLimitScope = "kind"
+	ScopeIP     RateLimitScope = "ip"
+)
+
+var rateLimitScopes = []RateLimitScope{ScopeClient, ScopeKind, ScopeIP}
+
+// Configures limits of inbound messages.
+type RateLimitOptions struct {
+	// Limits all messages of a connection, including the messages of its streams.
+	Client RateLimit
+	// Limits messages of a connection by protocol message type, e.g. BroadCastMessage or JoinRoomMessage.
+	Kinds map[int]RateLimit
+	// Limits the messages of all connections from the same remote IP.
+	IP RateLimit
+	// Applies to every exceeded limit. Defaults to LimitDrop.
+	Action RateLimitAction
+	// Close frame sent with LimitDisconnect. Defaults to 1008 (policy violation).
+	CloseCode   int
+	CloseReason string
+}
+
+// Enables rate limiting of inbound messages.
+func WithRateLimit(opts RateLimitOptions) Option {
+	return func(o *serverOptions) {
+		if opts.CloseCode == 0 {
+			opts.CloseCode = websocket.ClosePolicyViolation
+			opts.CloseReason = "rate limit exceeded"
+		}
+		opts.Client = opts.Client.withDefaults()
+		opts.IP = opts.IP.withDefaults()
+		kinds := make(map[int]RateLimit, len(opts.Kinds))
+		for kind, limit := range opts.Kinds {
+			kinds[kind] = limit.withDefaults()
+		}
+		opts.Kinds = kinds
+		o.rateLimit = &opts
+	}
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Takes a token, refilling the bucket for the time passed since the last call.
+func (b *bucket) take(limit RateLimit, now time.Time) bool {
+	if limit.Rate <= 0 {
+		return true
+	}
+	if b.last.IsZero() {
+		b.tokens = float64(limit.Burst)
+	} else {
+		b.tokens = min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Reports whether the bucket refilled completely and can be forgotten.
+func (b *bucket) full(limit RateLimit, now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*limit.Rate >= float64(limit.Burst)
+}
+
+// Buckets of a connection.
+type clientLimiter struct {
+	client bucket
+	kinds  map[int]*bucket
+	mu     sync.Mutex
+}
+
+// Buckets of remote IPs, shared by the connections of a server.
+type ipLimiter struct {
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+func (l *ipLimiter) take(ip string, limit RateLimit, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > time.Minute {
+		for key, b := range l.buckets {
+			if b.full(limit, now) {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = new(bucket)
+		l.buckets[ip] = b
+	}
+	return b.take(limit, now)
+}
+
+// Returns the scope of the first limit the message exceeds, or an empty scope if it is allowed.
+func (c *Client) checkRateLimit(msgType int, message []byte) RateLimitScope {
+	opts := c.hub.server.options.rateLimit
+	now := time.Now()
+
+	c.limiter.mu.Lock()
+	allowed := c.limiter.client.take(opts.Client, now)
+	kindAllowed := true
+	if allowed {
+		kindAllowed = c.takeKind(msgType, message, now)
+	}
+	c.limiter.mu.Unlock()
+
+	switch {
+	case !allowed:
+		return ScopeClient
+	case !kindAllowed:
+		return ScopeKind
+	case opts.IP.Rate > 0 && !c.hub.server.ipLimiter.take(remoteIP(c.RemoteAddr()), opts.IP, now):
+		return ScopeIP
+	}
+	return ""
+}
+
+// Reports whether the message of a stream is within the limit of its frame type. The messages of streams share
+// the buckets of their connection, the connection already charged the frame wrapping them to the client limit.
+func (c *Client) checkStreamRateLimit(msgType int, message []byte) bool {
+	if c.hub.server.options.rateLimit == nil {
+		return true
+	}
+	c.limiter.mu.Lock()
+	defer c.limiter.mu.Unlock()
+	return c.takeKind(msgType, message, time.Now())
+}
+
+// Takes a token of the bucket of the frame type of the message, if the type is limited. Must be called with
+// the limiter lock held.
+func (c *Client) takeKind(msgType int, message []byte, now time.Time) bool {
+	frameType, isFrame := c.peekFrameType(msgType, message)
+	if !isFrame {
+		return true
+	}
+	kind := int(frameType)
+	limit, ok := c.hub.server.options.rateLimit.Kinds[kind]
+	if !ok {
+		return true
+	}
+	b, ok := c.limiter.kinds[kind]
+	if !ok {
+		b = new(bucket)
+		c.limiter.kinds[kind] = b
+	}
+	return b.take(limit, now)
+}
+
+// Applies the configured action to a message exceeding a rate limit.
+func (c *Client) rateLimited(scope RateLimitScope, msgType int, message []byte) {
+	opts := c.hub.server.options.rateLimit
+	frameType, isFrame := c.peekFrameType(msgType, message)
+	kind := receivedKind(msgType, frameType, isFrame)
+
+	c.stats.rateLimited.Add(1)
+	c.hub.server.metrics.rateLimited[scope].Add(1)
+	c.protect("rate_limit", func() {
+		c.hub.server.handlers.rateLimitHandler(c, scope, kind)
+	})
+
+	switch opts.Action {
+	case LimitError:
+		c.clientError("rate limit exceeded")
+	case LimitDisconnect:
+		c.logger.Warn("disconnecting client exceeding rate limit", "scope", scope, "type", kind)
+		c.terminate(opts.CloseCode, opts.CloseReason, "rate_limited")
+	default:
+		c.logger.Debug("message dropped by rate limit", "scope", scope, "type", kind)
+	}
+}
+
+func remoteIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// Triggered when a client exceeds a rate limit, before the configured action is applied.
+// kind is the message type as used by the metrics.
+func (s *Server) HandleRateLimit(fun func(client *Client, scope RateLimitScope, kind string)) {
+	s.handlers.rateLimitHandler = fun
+}
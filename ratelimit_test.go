@@ -0,0 +1,189 @@
+package axion
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestBucket(t *testing.T) {
+	limit := RateLimit{Rate: 2, Burst: 3}
+	now := time.Unix(0, 0)
+	var b bucket
+
+	for i := 0; i < 3; i++ {
+		if !b.take(limit, now) {
+			t.Fatalf("take %d within burst was refused", i)
+		}
+	}
+	if b.take(limit, now) {
+		t.Fatal("take beyond burst was allowed")
+	}
+	if !b.take(limit, now.Add(500*time.Millisecond)) {
+		t.Fatal("take after refill was refused")
+	}
+	if b.take(limit, now.Add(500*time.Millisecond)) {
+		t.Fatal("refill exceeded the rate")
+	}
+	if !b.full(limit, now.Add(2*time.Second)) {
+		t.Fatal("bucket did not refill to burst")
+	}
+}
+
+func TestWithRateLimitDefaultBurst(t *testing.T) {
+	o := new(serverOptions)
+	WithRateLimit(RateLimitOptions{Client: RateLimit{Rate: 0.5}, IP: RateLimit{Rate: 2.5}, Kinds: map[int]RateLimit{JoinRoomMessage: {Rate: 10}}})(o)
+	if o.rateLimit.Client.Burst != 1 || o.rateLimit.IP.Burst != 3 || o.rateLimit.Kinds[JoinRoomMessage].Burst != 10 {
+		t.Errorf("got bursts %d, %d and %d", o.rateLimit.Client.Burst, o.rateLimit.IP.Burst, o.rateLimit.Kinds[JoinRoomMessage].Burst)
+	}
+}
+
+// Serves s and returns a connection speaking the JSON codec and the server side client of it.
+func dialRateLimited(t *testing.T, s *Server) (*websocket.Conn, *Client) {
+	clients := make(chan *Client, 1)
+	s.HandleConnect(func(client *Client, r *http.Request) {
+		client.HandleText(func(message string) {
+			client.SendMessage(NewMessage(websocket.TextMessage, []byte(message)))
+		})
+		clients <- client
+	})
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	header := map[string][]string{"Sec-WebSocket-Protocol": {"axion.json"}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.ReadMessage()
+	return conn, <-clients
+}
+
+func TestRateLimitActions(t *testing.T) {
+	for _, action := range []RateLimitAction{LimitDrop, LimitError, LimitDisconnect} {
+		s := NewServer(WithRateLimit(RateLimitOptions{Client: RateLimit{Rate: 0.01}, Action: action}))
+		limited := make(chan string, 1)
+		s.HandleRateLimit(func(client *Client, scope RateLimitScope, kind string) {
+			limited <- string(scope) + " " + kind
+		})
+		conn, client := dialRateLimited(t, s)
+
+		conn.WriteMessage(websocket.TextMessage, []byte("first"))
+		conn.WriteMessage(websocket.TextMessage, []byte("second"))
+		if _, p, _ := conn.ReadMessage(); string(p) != "first" {
+			t.Errorf("action %d: got %q, want first", action, p)
+		}
+		if got := <-limited; got != "client text" {
+			t.Errorf("action %d: rate limit reported as %q", action, got)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, p, err := conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		switch action {
+		case LimitDrop:
+			if err == nil {
+				t.Errorf("drop: got %s, want nothing", p)
+			}
+		case LimitError:
+			if string(p) != `{"type":"client_error","data":"rate limit exceeded"}` {
+				t.Errorf("error: got %s, %v", p, err)
+			}
+		case LimitDisconnect:
+			if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation {
+				t.Errorf("disconnect: got %v, want close 1008", err)
+			}
+		}
+		if n := client.Stats().RateLimited; n != 1 {
+			t.Errorf("action %d: %d messages counted as rate limited", action, n)
+		}
+	}
+}
+
+func TestRateLimitKinds(t *testing.T) {
+	s := NewServer(WithRateLimit(RateLimitOptions{Kinds: map[int]RateLimit{JoinRoomMessage: {Rate: 0.01}}, Action: LimitError}))
+	s.CreateRoomWithId("lobby")
+	conn, client := dialRateLimited(t, s)
+
+	for _, test := range []struct {
+		sent string
+		want string
+	}{
+		{`{"type":"join_room","room":"lobby"}`, `{"type":"client_joined"`},
+		{`hello`, `hello`},
+		{`{"type":"join_room","room":"lobby"}`, `{"type":"client_error","data":"rate limit exceeded"}`},
+		{`{"type":"leave_room","room":"lobby"}`, `{"type":"client_left"`},
+	} {
+		conn.WriteMessage(websocket.TextMessage, []byte(test.sent))
+		if _, p, _ := conn.ReadMessage(); !strings.HasPrefix(string(p), test.want) {
+			t.Errorf("sent %s: got %s, want %s", test.sent, p, test.want)
+		}
+	}
+	if n := client.Stats().RateLimited; n != 1 {
+		t.Errorf("%d messages counted as rate limited, want 1", n)
+	}
+}
+
+func TestRateLimitKindsInStreams(t *testing.T) {
+	s := NewServer(WithRateLimit(RateLimitOptions{Kinds: map[int]RateLimit{JoinRoomMessage: {Rate: 0.01}}, Action: LimitError}))
+	limited := make(chan string, 2)
+	s.HandleRateLimit(func(client *Client, scope RateLimitScope, kind string) {
+		limited <- string(scope) + " " + kind
+	})
+	lobby := s.Namespace("/chat").CreateRoom().Id()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.ReadMessage()
+
+	write := func(f Frame) {
+		p, err := EncodeFrame(ProtocolV1, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.WriteMessage(websocket.BinaryMessage, p)
+	}
+	read := func() Frame {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, p, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, _ := DecodeFrame(ProtocolV1, p)
+		return f
+	}
+	join, _ := EncodeFrame(ProtocolV1, Frame{Type: JoinRoomMessage, RoomId: lobby})
+	inStream := append([]byte{0, 0, 0, 1, websocket.BinaryMessage}, join...)
+
+	write(Frame{Type: OpenStreamMessage, Payload: append([]byte{0, 0, 0, 1}, "/chat"...)})
+	if f := read(); f.Type != SigStreamOpened {
+		t.Fatalf("open stream: got %#x %q", f.Type, f.Payload)
+	}
+	write(Frame{Type: StreamMessage, Payload: inStream})
+	if f := read(); f.Type != StreamMessage {
+		t.Errorf("first join: got %#x %q", f.Type, f.Payload)
+	}
+
+	// The second join exceeds the limit of its type although the wrapping stream frame is not limited.
+	write(Frame{Type: StreamMessage, Payload: inStream})
+	if f := read(); f.Type != SigClientError || string(f.Payload) != "rate limit exceeded" {
+		t.Errorf("second join: got %#x %q", f.Type, f.Payload)
+	}
+	if got := <-limited; got != "kind join_room" {
+		t.Errorf("rate limit reported as %q", got)
+	}
+	if room, _ := s.Namespace("/chat").GetRoomById(lobby); len(room.Members()) != 1 {
+		t.Errorf("room has %d members, want 1", len(room.Members()))
+	}
+}
@@ -6,35 +6,41 @@ import (
 )
 
 type Room struct {
-	id        string
-	hub       *Hub
-	broadcast chan WsMessage
-	clients   []*Client
-	mu        sync.RWMutex
+	id      string
+	hub     *Hub
+	clients []*Client
+	policy  RoomPolicy
+	owner   string
+	invited map[string]struct{}
+	mu      sync.RWMutex
 }
 
 func newRoom(id string, hub *Hub) *Room {
 	return &Room{
-		id:        id,
-		hub:       hub,
-		broadcast: make(chan WsMessage),
-		clients:   make([]*Client, 0),
+		id:      id,
+		hub:     hub,
+		clients: make([]*Client, 0),
+		invited: make(map[string]struct{}),
 	}
 }
 
 func (r *Room) addClient(client *Client) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
 	r.clients = append(r.clients, client)
+	r.mu.Unlock()
+
 	r.BroadcastMessage(NewClientJoinedMessage(r.id, client.id))
 }
 
 func (r *Room) removeClient(client *Client) {
+	r.BroadcastMessage(NewClientLeftMessage(r.id, client.id))
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	r.BroadcastMessage(NewClientLeftMessage(r.id, client.id))
 	index := slices.Index(r.clients, client)
-	r.clients = slices.Delete(r.clients, index, index+1)
+	if index >= 0 {
+		r.clients = slices.Delete(r.clients, index, index+1)
+	}
 }
 
 // Returns the room id.
@@ -51,30 +57,42 @@ func (r *Room) Members() []*Client {
 
 // Broadcasts a message with the given websocket message type and content to all clients in the room.
 func (r *Room) Broadcast(msgType int, content []byte) {
-	r.broadcast <- NewMessage(msgType, content)
+	r.BroadcastMessage(NewMessage(msgType, content))
 }
 
-// Broadcasts a message to all clients in the room.
+// Broadcasts a message to all clients in the room. The message is handed to the members directly
+// from the calling go routine, so rooms do not contend with each other.
 func (r *Room) BroadcastMessage(message WsMessage) {
-	r.broadcast <- message
+	r.mu.RLock()
+	members := slices.Clone(r.clients)
+	r.mu.RUnlock()
+
+	r.hub.server.metrics.fanout.observe(len(members))
+	for _, client := range members {
+		client.SendMessage(message)
+	}
 }
 
 // Closes the room. Sends a RoomAbandoned message to all members and removes them from the room.
 func (r *Room) Close() {
+	r.hub.server.options.logger.Debug("room closed", "room_id", r.id, "namespace", r.hub.namespace.name)
 	r.BroadcastMessage(NewRoomAbandonedMessage(r.Id()))
 
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-	for _, c := range r.clients {
-		c.mu.Lock()
-		defer c.mu.Unlock()
+	// The hub takes its own lock before the locks of rooms, so the lock of the room is released first.
+	r.mu.Lock()
+	members := r.clients
+	r.clients = make([]*Client, 0)
+	r.mu.Unlock()
 
+	for _, c := range members {
+		c.mu.Lock()
 		index := slices.Index(c.rooms, r)
-		c.rooms = slices.Delete(c.rooms, index, index+1)
+		if index >= 0 {
+			c.rooms = slices.Delete(c.rooms, index, index+1)
+		}
+		c.mu.Unlock()
 	}
 
-	close(r.broadcast)
-
 	r.hub.mu.Lock()
 	defer r.hub.mu.Unlock()
 	delete(r.hub.rooms, r.id)
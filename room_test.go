@@ -0,0 +1,140 @@
+package axion
+
+import (
+	"fmt"
+	runtimemetrics "runtime/metrics"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Creates a room with members whose outgoing messages are discarded.
+func newBenchRoom(server *Server, members int) *Room {
+	room := server.CreateRoom()
+	for i := 0; i < members; i++ {
+		client := newClient(server.hub, nil, strconv.Itoa(i))
+		go func() {
+			for range client.queue.ready {
+				if _, closed := client.queue.drain(); closed {
+					return
+				}
+			}
+		}()
+		client.JoinRoom(room)
+	}
+	return room
+}
+
+func userCPUSeconds() float64 {
+	sample := []runtimemetrics.Sample{{Name: "/cpu/classes/user:cpu-seconds"}}
+	runtimemetrics.Read(sample)
+	return sample[0].Value.Float64()
+}
+
+func BenchmarkRoomBroadcast(b *testing.B) {
+	for _, rooms := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("rooms=%d", rooms), func(b *testing.B) {
+			server := NewServer(WithSendQueue(SendQueueOptions{Size: 256, Policy: PolicyBlock}))
+			targets := make([]*Room, rooms)
+			for i := range targets {
+				targets[i] = newBenchRoom(server, 8)
+			}
+			message := NewBinaryMessage([]byte("benchmark"))
+			var next atomic.Int64
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				room := targets[int(next.Add(1))%rooms]
+				for pb.Next() {
+					room.BroadcastMessage(message)
+				}
+			})
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "msgs/s")
+		})
+	}
+}
+
+func BenchmarkIdleHub(b *testing.B) {
+	server := NewServer()
+	for i := 0; i < 64; i++ {
+		newBenchRoom(server, 8)
+	}
+
+	b.ResetTimer()
+	start := userCPUSeconds()
+	for i := 0; i < b.N; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	idle := b.Elapsed().Seconds()
+	b.ReportMetric((userCPUSeconds()-start)/idle, "cpu-s/s")
+}
+
+func TestRoomClose(t *testing.T) {
+	server := NewServer()
+	room := newBenchRoom(server, 2)
+	other := server.CreateRoom()
+	member := room.Members()[0]
+	member.JoinRoom(other)
+
+	room.Close()
+	if n := len(room.Members()); n != 0 {
+		t.Errorf("%d members left", n)
+	}
+	if rooms := member.Rooms(); len(rooms) != 1 || rooms[0] != other {
+		t.Errorf("member still in %v", rooms)
+	}
+	if _, ok := server.GetRoomById(room.Id()); ok {
+		t.Error("closed room still registered")
+	}
+}
+
+func TestRoomCloseDuringShutdown(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		server := NewServer()
+		room := newBenchRoom(server, 4)
+
+		done := make(chan struct{})
+		go func() {
+			room.Close()
+			close(done)
+		}()
+		server.hub.closeAll()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("room close deadlocked with shutdown")
+		}
+	}
+}
+
+func TestRoomCloseAfterLeaveRooms(t *testing.T) {
+	server := NewServer()
+	room := newBenchRoom(server, 1)
+	// The client already left all rooms on disconnect but is still listed as member.
+	member := room.Members()[0]
+	member.mu.Lock()
+	member.rooms = nil
+	member.mu.Unlock()
+
+	room.Close()
+	if n := len(room.Members()); n != 0 {
+		t.Errorf("%d members left", n)
+	}
+}
+
+func TestRoomBroadcastAfterSessionEnded(t *testing.T) {
+	server := NewServer()
+	room := newBenchRoom(server, 2)
+	// The session of a member ends while it is still listed in the room.
+	member := room.Members()[0]
+	server.hub.mu.Lock()
+	server.hub.clients[member.id] = member
+	server.hub.removeClient(member, "closed")
+	server.hub.mu.Unlock()
+
+	room.Broadcast(websocket.TextMessage, []byte("still delivered to the others"))
+	member.SendMessage(NewMessage(websocket.TextMessage, []byte("dropped")))
+}
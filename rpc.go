@@ -0,0 +1,207 @@
+package axion
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Returned by Client.Request if the session of the client ends before the reply arrives.
+var ErrClientClosed = errors.New("axion: client closed")
+
+// Replied to requests beyond the concurrency limit of a connection.
+var errTooManyRequests = errors.New("too many concurrent requests")
+
+const defaultMaxRequests = 64
+
+// Sets how many requests of a connection, including those of its streams, are handled at the same time.
+// Further requests are answered with SigServerError until one finishes. Defaults to 64, a limit of zero or
+// less is ignored.
+func WithMaxConcurrentRequests(limit int) Option {
+	return func(o *serverOptions) {
+		if limit > 0 {
+			o.maxRequests = limit
+		}
+	}
+}
+
+// Error reply of a request. Code is SigClientError or SigServerError.
+type RequestError struct {
+	Code    int
+	Message string
+}
+
+func (e *RequestError) Error() string {
+	if e.Code == SigClientError {
+		return "client error: " + e.Message
+	}
+	return "server error: " + e.Message
+}
+
+// Creates an error which request handlers return to reply with SigClientError, e.g. for invalid payloads.
+// All other errors are replied with SigServerError.
+func NewClientError(message string) error {
+	return &RequestError{Code: SigClientError, Message: message}
+}
+
+type reply struct {
+	payload []byte
+	err     error
+}
+
+// Creates a new Request message: correlation id, method length (2 bytes), method and payload.
+func newRequestMessage(requestId uint32, method string, payload []byte) WsMessage {
+	p := make([]byte, 0, 6+len(method)+len(payload))
+	p = binary.BigEndian.AppendUint32(p, requestId)
+	p = binary.BigEndian.AppendUint16(p, uint16(len(method)))
+	p = append(p, method...)
+	p = append(p, payload...)
+	return NewFrameMessage(Frame{Type: RequestMessage, Payload: p})
+}
+
+// Creates a new Response message: correlation id, status (0, SigClientError or SigServerError) and payload.
+// Error replies carry the error message as payload.
+func newResponseMessage(requestId uint32, payload []byte, err error) WsMessage {
+	status := uint32(0)
+	if err != nil {
+		status = SigServerError
+		var requestErr *RequestError
+		if errors.As(err, &requestErr) {
+			status = uint32(requestErr.Code)
+			payload = []byte(requestErr.Message)
+		} else {
+			payload = []byte(err.Error())
+		}
+	}
+	p := make([]byte, 0, 8+len(payload))
+	p = binary.BigEndian.AppendUint32(p, requestId)
+	p = binary.BigEndian.AppendUint32(p, status)
+	p = append(p, payload...)
+	return NewFrameMessage(Frame{Type: ResponseMessage, Payload: p})
+}
+
+// Sends a request to the client and waits for its reply. Returns a *RequestError if the client replied
+// with an error and the context error if ctx expires first.
+func (c *Client) Request(ctx context.Context, method string, payload []byte) ([]byte, error) {
+	requestId := c.requestId.Add(1)
+	pending := make(chan reply, 1)
+
+	c.mu.Lock()
+	if c.pending == nil {
+		c.mu.Unlock()
+		return nil, ErrClientClosed
+	}
+	c.pending[requestId] = pending
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		if c.pending != nil {
+			delete(c.pending, requestId)
+		}
+		c.mu.Unlock()
+	}()
+
+	c.SendMessage(newRequestMessage(requestId, method, payload))
+
+	select {
+	case r := <-pending:
+		return r.payload, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("request %s: %w", method, ctx.Err())
+	}
+}
+
+// Handles requests for the method sent by the client. The returned reply or error is sent back to the client.
+// Handlers run in their own go routine, at most WithMaxConcurrentRequests per connection, and may send requests
+// to the client themselves.
+// Registering a handler for the same method again replaces it.
+func (c *Client) HandleRequest(method string, fun func(ctx context.Context, payload []byte) ([]byte, error)) func() {
+	return c.handlers.requestHandlers.set(method, fun)
+}
+
+func (c *Client) readRequest(rest []byte) {
+	if len(rest) < 6 {
+		c.clientError("malformed request")
+		return
+	}
+	requestId := binary.BigEndian.Uint32(rest[:4])
+	length := int(binary.BigEndian.Uint16(rest[4:6]))
+	if len(rest) < 6+length {
+		c.clientError("malformed request")
+		return
+	}
+	method := string(rest[6 : 6+length])
+	payload := rest[6+length:]
+
+	if !c.Capabilities().Has(CapRPC) {
+		c.SendMessage(newResponseMessage(requestId, nil, NewClientError("requests not negotiated")))
+		return
+	}
+	handler, ok := c.handlers.requestHandlers.get(method)
+	if !ok {
+		c.SendMessage(newResponseMessage(requestId, nil, NewClientError("unknown method "+method)))
+		return
+	}
+
+	requests := c.Connection().requests
+	select {
+	case requests <- struct{}{}:
+	default:
+		c.logger.Warn("request rejected", "method", method, "error", errTooManyRequests)
+		c.SendMessage(newResponseMessage(requestId, nil, errTooManyRequests))
+		return
+	}
+
+	ctx := c.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	go func() {
+		var reply []byte
+		var err error
+		if !c.protect("request", func() { reply, err = handler(ctx, payload) }) {
+			reply, err = nil, errInternal
+		}
+		// The slot is free before the reply, so the client may send the next request right away.
+		<-requests
+		c.SendMessage(newResponseMessage(requestId, reply, err))
+	}()
+}
+
+func (c *Client) readResponse(rest []byte) {
+	if len(rest) < 8 {
+		c.clientError("malformed response")
+		return
+	}
+	requestId := binary.BigEndian.Uint32(rest[:4])
+	status := int(binary.BigEndian.Uint32(rest[4:8]))
+	payload := rest[8:]
+
+	c.mu.Lock()
+	pending, ok := c.pending[requestId]
+	delete(c.pending, requestId)
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if status != 0 {
+		pending <- reply{err: &RequestError{Code: status, Message: string(payload)}}
+		return
+	}
+	pending <- reply{payload: payload}
+}
+
+// Fails all requests waiting for a reply.
+func (c *Client) cancelRequests() {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+
+	for _, p := range pending {
+		p <- reply{err: ErrClientClosed}
+	}
+}
@@ -0,0 +1,165 @@
+package axion
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRequests(t *testing.T) {
+	s := NewServer()
+	clients := make(chan *Client, 1)
+	s.HandleConnect(func(client *Client, r *http.Request) {
+		client.HandleRequest("upper", func(ctx context.Context, payload []byte) ([]byte, error) {
+			return bytes.ToUpper(payload), nil
+		})
+		client.HandleRequest("validate", func(ctx context.Context, payload []byte) ([]byte, error) {
+			return nil, NewClientError("invalid input")
+		})
+		client.HandleRequest("store", func(ctx context.Context, payload []byte) ([]byte, error) {
+			return nil, errors.New("database down")
+		})
+		clients <- client
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.ReadMessage()
+	client := <-clients
+
+	// Returns the type and the payload of the next message.
+	read := func() (uint32, []byte) {
+		_, p, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return binary.BigEndian.Uint32(p), p[4:]
+	}
+	write := func(message WsMessage) {
+		conn.WriteMessage(websocket.BinaryMessage, message.content)
+	}
+
+	tests := []struct {
+		method string
+		status uint32
+		reply  string
+	}{
+		{"upper", 0, "HELLO"},
+		{"validate", SigClientError, "invalid input"},
+		{"store", SigServerError, "database down"},
+		{"missing", SigClientError, "unknown method missing"},
+	}
+	for i, test := range tests {
+		requestId := uint32(i + 1)
+		write(newRequestMessage(requestId, test.method, []byte("hello")))
+		kind, payload := read()
+		if kind != ResponseMessage || binary.BigEndian.Uint32(payload) != requestId {
+			t.Fatalf("%s: got %#x %q", test.method, kind, payload)
+		}
+		if status, reply := binary.BigEndian.Uint32(payload[4:]), string(payload[8:]); status != test.status || reply != test.reply {
+			t.Errorf("%s: got status %#x and %q, want %#x and %q", test.method, status, reply, test.status, test.reply)
+		}
+	}
+
+	// Requests of the server are answered by the peer with the correlation id of the request.
+	type result struct {
+		payload []byte
+		err     error
+	}
+	results := make(chan result, 1)
+	request := func(method string) []byte {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			payload, err := client.Request(ctx, method, []byte("ping"))
+			results <- result{payload, err}
+		}()
+		_, payload := read()
+		return payload
+	}
+
+	payload := request("greet")
+	write(newResponseMessage(binary.BigEndian.Uint32(payload), []byte("pong"), nil))
+	if r := <-results; r.err != nil || string(r.payload) != "pong" {
+		t.Errorf("greet: got %q, %v", r.payload, r.err)
+	}
+
+	payload = request("greet")
+	write(newResponseMessage(binary.BigEndian.Uint32(payload), nil, NewClientError("not now")))
+	var requestErr *RequestError
+	if r := <-results; !errors.As(r.err, &requestErr) || requestErr.Code != SigClientError || requestErr.Message != "not now" {
+		t.Errorf("greet: got %v, want client error", r.err)
+	}
+
+	request("greet")
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	if r := <-results; !errors.Is(r.err, ErrClientClosed) {
+		t.Errorf("pending request: got %v, want ErrClientClosed", r.err)
+	}
+}
+
+func TestMaxConcurrentRequests(t *testing.T) {
+	s := NewServer(WithMaxConcurrentRequests(1))
+	release := make(chan struct{})
+	s.HandleConnect(func(client *Client, r *http.Request) {
+		client.HandleRequest("wait", func(ctx context.Context, payload []byte) ([]byte, error) {
+			<-release
+			return []byte("done"), nil
+		})
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.ReadMessage()
+
+	// Returns the correlation id, status and reply of the next response.
+	read := func() (uint32, uint32, string) {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, p, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if binary.BigEndian.Uint32(p) != ResponseMessage {
+			t.Fatalf("expected response, got %q", p)
+		}
+		return binary.BigEndian.Uint32(p[4:]), binary.BigEndian.Uint32(p[8:]), string(p[12:])
+	}
+	write := func(requestId uint32) {
+		conn.WriteMessage(websocket.BinaryMessage, newRequestMessage(requestId, "wait", nil).content)
+	}
+
+	write(1)
+	write(2)
+	if id, status, reply := read(); id != 2 || status != SigServerError || reply != "too many concurrent requests" {
+		t.Errorf("request beyond the limit: got %d %#x %q", id, status, reply)
+	}
+	release <- struct{}{}
+	if id, status, reply := read(); id != 1 || status != 0 || reply != "done" {
+		t.Errorf("first request: got %d %#x %q", id, status, reply)
+	}
+
+	// The slot is free again once the handler returned.
+	write(3)
+	release <- struct{}{}
+	if id, status, reply := read(); id != 3 || status != 0 || reply != "done" {
+		t.Errorf("request after the limit: got %d %#x %q", id, status, reply)
+	}
+}
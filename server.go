@@ -1,44 +1,249 @@
 package axion
 
 import (
+	"context"
+	"errors"
+	"log/slog"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 )
 
-// TODO Multiplexing
-
 type ServerHandlers struct {
-	upgradeHandler func(w http.ResponseWriter, r *http.Request, connect func())
-	connectHandler func(client *Client, r *http.Request)
+	upgradeHandler      func(w http.ResponseWriter, r *http.Request, connect func())
+	slowConsumerHandler func(client *Client, policy SlowConsumerPolicy, dropped WsMessage)
+	rateLimitHandler    func(client *Client, scope RateLimitScope, kind string)
+	panicHandler        func(client *Client, handler string, value any, stack []byte)
 }
 
+// Returned by Shutdown if the server has already been shut down.
+var ErrServerClosed = errors.New("axion: server closed")
+
+const (
+	stateRunning int32 = iota
+	stateDraining
+	stateClosed
+)
+
 type Server struct {
 	hub        *Hub
 	handlers   *ServerHandlers
+	middleware handlerList[Middleware]
+	namespaces map[string]*Namespace
 	httpServer *http.Server
+	options    *serverOptions
+	upgrader   *websocket.Upgrader
+	ipLimiter  *ipLimiter
+	metrics    *metrics
+	state      atomic.Int32
+	mu         sync.Mutex
+}
+
+type serverOptions struct {
+	path               string
+	sessionGracePeriod time.Duration
+	replayBufferSize   int
+	closeCode          int
+	closeReason        string
+	sendQueue          SendQueueOptions
+	logger             *slog.Logger
+	logPayloads        bool
+	authenticator      Authenticator
+	upgrade            upgradeOptions
+	rateLimit          *RateLimitOptions
+	readLimit          int64
+	pingPeriod         time.Duration
+	pongWait           time.Duration
+	writeTimeout       time.Duration
+	versions           []int
+	codecs             []Codec
+	eventEncoding      Encoding
+	panics             PanicOptions
+	maxRequests        int
+}
+
+// Configures optional behaviour of a server.
+type Option func(o *serverOptions)
+
+// Sets the path ListenAndServe mounts the websocket endpoint on. Defaults to "/ws".
+func WithPath(path string) Option {
+	return func(o *serverOptions) {
+		o.path = path
+	}
+}
+
+// Sets the close code and reason sent to every client on Shutdown. Defaults to 1001 (going away).
+func WithShutdownClose(code int, reason string) Option {
+	return func(o *serverOptions) {
+		o.closeCode = code
+		o.closeReason = reason
+	}
 }
 
-// Creates a new Axion instance on the provided http server.
-func NewServer(httpServer *http.Server) *Server {
+// Creates a new Axion instance. The server is an http.Handler and can be mounted on any router,
+// or served on its own with ListenAndServe.
+func NewServer(opts ...Option) *Server {
 	s := &Server{
 		handlers:   new(ServerHandlers),
-		httpServer: httpServer,
+		namespaces: make(map[string]*Namespace),
+		metrics:    newMetrics(),
+		ipLimiter:  &ipLimiter{buckets: make(map[string]*bucket)},
+		options: &serverOptions{
+			path:          "/ws",
+			closeCode:     websocket.CloseGoingAway,
+			closeReason:   "server shutting down",
+			sendQueue:     defaultSendQueueOptions,
+			logger:        slog.New(discardHandler{}),
+			readLimit:     defaultReadLimit,
+			pingPeriod:    defaultPongWait * 9 / 10,
+			pongWait:      defaultPongWait,
+			writeTimeout:  defaultWriteTimeout,
+			versions:      []int{ProtocolV1, ProtocolV2},
+			codecs:        []Codec{JSONCodec{}, MessagePackCodec{}},
+			eventEncoding: JSONEncoding{},
+			panics:        defaultPanicOptions,
+			maxRequests:   defaultMaxRequests,
+			upgrade: upgradeOptions{
+				readBufferSize:  1024,
+				writeBufferSize: 1024,
+			},
+		},
 	}
+	for _, opt := range opts {
+		opt(s.options)
+	}
+	s.upgrader = s.newUpgrader()
 	s.handlers.upgradeHandler = func(w http.ResponseWriter, r *http.Request, connect func()) { connect() }
-	s.handlers.connectHandler = func(client *Client, r *http.Request) {}
+	s.handlers.slowConsumerHandler = func(client *Client, policy SlowConsumerPolicy, dropped WsMessage) {}
+	s.handlers.rateLimitHandler = func(client *Client, scope RateLimitScope, kind string) {}
+	s.handlers.panicHandler = func(client *Client, handler string, value any, stack []byte) {}
 
-	hub := newHub(s)
-	s.hub = hub
-	go hub.run()
+	ns := newNamespace(DefaultNamespace, s)
+	s.namespaces[DefaultNamespace] = ns
+	s.hub = ns.hub
 
-	http.HandleFunc("/ws", hub.handleNewConnection)
 	return s
 }
 
-// Listen with the underlying http server. Blocks the current go routine.
-func (s *Server) ListenAndServe() {
-	s.httpServer.ListenAndServe()
+// Returns the namespace with the given name and creates it if it does not exist yet.
+// Clients open namespaces as streams on their connection, the server itself serves DefaultNamespace.
+func (s *Server) Namespace(name string) *Namespace {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ns, ok := s.namespaces[name]
+	if !ok {
+		ns = newNamespace(name, s)
+		s.namespaces[name] = ns
+	}
+	return ns
+}
+
+func (s *Server) getNamespace(name string) (*Namespace, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ns, ok := s.namespaces[name]
+	return ns, ok
+}
+
+// Handles websocket upgrade requests on whatever path the server is mounted on.
+// Responds with 503 while the server is draining or shut down.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.state.Load() != stateRunning {
+		s.options.logger.Debug("upgrade refused while draining", "remote_addr", r.RemoteAddr)
+		s.metrics.upgradesRejected.Add(1)
+		s.upgradeError(w, r, http.StatusServiceUnavailable, errors.New("server is shutting down"))
+		return
+	}
+	r, ok := s.authenticate(w, r)
+	if !ok {
+		s.metrics.upgradesRejected.Add(1)
+		return
+	}
+	s.hub.handleNewConnection(w, r)
+}
+
+// Returns the path ListenAndServe mounts the websocket endpoint on.
+func (s *Server) Path() string {
+	return s.options.path
+}
+
+// Listens on the tcp address addr and serves the websocket endpoint on the configured path. Blocks the current go routine.
+func (s *Server) ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle(s.options.path, s)
+	httpServer := &http.Server{Addr: addr, Handler: mux}
+
+	s.mu.Lock()
+	s.httpServer = httpServer
+	s.mu.Unlock()
+
+	return httpServer.ListenAndServe()
+}
+
+// Puts the server into drain mode: new connections and session resumes are refused while connected
+// clients stay served. Blocks until all clients are gone or ctx expires.
+func (s *Server) Drain(ctx context.Context) error {
+	if s.state.CompareAndSwap(stateRunning, stateDraining) {
+		s.options.logger.Info("draining")
+	}
+
+	select {
+	case <-s.hub.drained():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reports whether the server refuses new connections.
+func (s *Server) Draining() bool {
+	return s.state.Load() != stateRunning
+}
+
+// Gracefully shuts the server down. Stops accepting upgrades, sends a close frame with the configured
+// code and reason to every client after its pending messages, closes all rooms and stops the hub.
+// Returns once all clients are closed or ctx expires.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.state.Swap(stateClosed) == stateClosed {
+		return ErrServerClosed
+	}
+	s.options.logger.Info("shutting down")
+
+	s.mu.Lock()
+	httpServer := s.httpServer
+	s.mu.Unlock()
+
+	var err error
+	if httpServer != nil {
+		err = httpServer.Shutdown(ctx)
+	}
+
+	// Streams end with their connections, so the default namespace goes first.
+	clients := s.hub.shutdown()
+
+	// Disconnect handlers may look up namespaces, so they run without the lock.
+	s.mu.Lock()
+	namespaces := make([]*Namespace, 0, len(s.namespaces))
+	for _, ns := range s.namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	s.mu.Unlock()
+	for _, ns := range namespaces {
+		ns.hub.shutdown()
+	}
+
+	for _, client := range clients {
+		select {
+		case <-client.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
 }
 
 // Returns all clients.
@@ -75,22 +280,33 @@ func (s *Server) BroadcastMessage(message WsMessage) {
 
 // Creates and returns a new empty room.
 func (s *Server) CreateRoom() *Room {
-	id := uuid.New().String()
-	room := newRoom(id, s.hub)
+	return s.hub.createRoom()
+}
 
-	s.hub.mu.Lock()
-	defer s.hub.mu.Unlock()
+// Creates a room with the given id. Reports false if the id is taken.
+// Clients speaking protocol version 1 can only address rooms with uuids as id.
+func (s *Server) CreateRoomWithId(id string) (*Room, bool) {
+	return s.hub.createRoomWithId(id)
+}
 
-	s.hub.rooms[id] = room
-	return room
+// Sets the policy of rooms clients open. Its Roles entry for ActionOpen restricts which clients may open rooms.
+func (s *Server) SetRoomPolicy(policy RoomPolicy) {
+	s.hub.namespace.SetRoomPolicy(policy)
 }
 
-// Handles incomming upgrade requests (on the path /ws). Call connect to accept the upgrade.
+// Handles incomming upgrade requests. Call connect to accept the upgrade.
+// The identity of an authenticated request is available through IdentityFromContext(r.Context()).
 func (s *Server) HandleUpgrade(fun func(w http.ResponseWriter, r *http.Request, connect func())) {
 	s.handlers.upgradeHandler = fun
 }
 
 // Handles new connected clients
 func (s *Server) HandleConnect(fun func(client *Client, r *http.Request)) {
-	s.handlers.connectHandler = fun
+	s.hub.namespace.handlers.connectHandler = fun
+}
+
+// Handles messages dropped because the send queue of a client was full, including the message
+// that triggered a disconnect under PolicyDisconnect.
+func (s *Server) HandleSlowConsumer(fun func(client *Client, policy SlowConsumerPolicy, dropped WsMessage)) {
+	s.handlers.slowConsumerHandler = fun
 }
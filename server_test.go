@@ -1,12 +1,18 @@
 package axion
 
 import (
-	axlog "axion/log"
 	"context"
+	"errors"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 type SomeText string
@@ -15,11 +21,14 @@ type ClientMeta struct {
 	header http.Header
 }
 
+// Set AXION_DEV_SERVER to run a development server on :8080 for two minutes instead of the tests.
 func TestMain(m *testing.M) {
-	os.Setenv("ENV_MODE", "dev")
-
+	if os.Getenv("AXION_DEV_SERVER") == "" {
+		os.Exit(m.Run())
+	}
 	go func() {
-		server := NewServer(&http.Server{Addr: ":8080"})
+		logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		server := NewServer(WithLogger(logger), WithPayloadLogging())
 
 		server.HandleUpgrade(func(w http.ResponseWriter, r *http.Request, connect func()) {
 			connect()
@@ -30,15 +39,15 @@ func TestMain(m *testing.M) {
 			client.SetContext(ctx)
 
 			client.HandleText(func(message string) {
-				axlog.Loglf("text message from client %s: %s", client.Id(), message)
+				logger.Info("text message", "client_id", client.Id(), "content", message)
 			})
 
 			client.HandleBinary(func(p []byte) {
-				axlog.Loglf("message from client %s: %s", client.Id(), p)
+				logger.Info("binary message", "client_id", client.Id(), "content", p)
 			})
 
 			client.HandleBroadcast(func(p []byte) {
-				axlog.Logln("recieved broadcast (not allowed)")
+				logger.Info("recieved broadcast (not allowed)", "client_id", client.Id())
 			})
 
 			client.HandleOpenRoom(func(joinAfterwards bool, rest []byte) {
@@ -72,34 +81,209 @@ func TestMain(m *testing.M) {
 					client.SendMessage(NewClientErrorMessage("room not found"))
 					return
 				}
-				room.Broadcast(0, message)
+				room.Broadcast(websocket.BinaryMessage, message)
 			})
 
 			client.HandleClose(func(p []byte) {
-				axlog.Loglf("close message received from client %s", client.Id())
+				logger.Info("close message received", "client_id", client.Id())
 			})
 
 			client.HandlePing(func(p []byte) {
-				axlog.Loglf("ping message received from client %s", client.Id())
+				logger.Info("ping message received", "client_id", client.Id())
 			})
 
 			client.HandlePong(func(p []byte) {
-				axlog.Loglf("pong message received from client %s", client.Id())
+				logger.Info("pong message received", "client_id", client.Id())
 			})
 
 			client.HandleClose(func(p []byte) {
-				axlog.Loglf("client %s sent close", client.Id())
+				logger.Info("client sent close", "client_id", client.Id())
 			})
 
-			client.HandleDisconnect(func() {
-				axlog.Loglf("client %s unregistered", client.Id())
+			client.HandleDisconnect(func(status CloseStatus) {
+				logger.Info("client unregistered", "client_id", client.Id(), "code", status.Code, "initiator", status.Initiator.String())
 			})
 		})
 
-		server.ListenAndServe()
+		server.ListenAndServe(":8080")
 	}()
 
 	time.Sleep(2 * time.Minute)
 
 	os.Exit(0)
 }
+
+func TestServeMux(t *testing.T) {
+	chat, game := NewServer(), NewServer()
+	mux := http.NewServeMux()
+	mux.Handle("/chat/ws", chat)
+	mux.Handle("/game/ws", game)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+	dial := func(s *Server, path string) string {
+		conn, _, err := websocket.DefaultDialer.Dial(url+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		id, _ := readInit(t, conn)
+		for deadline := time.Now().Add(time.Second); ; time.Sleep(5 * time.Millisecond) {
+			if _, ok := s.GetClientById(id); ok {
+				return id
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("client %s not registered on the server of %s", id, path)
+			}
+		}
+	}
+	chatId := dial(chat, "/chat/ws")
+	gameId := dial(game, "/game/ws")
+
+	if _, ok := game.GetClientById(chatId); ok {
+		t.Error("chat client registered on the game server")
+	}
+	if _, ok := chat.GetClientById(gameId); ok {
+		t.Error("game client registered on the chat server")
+	}
+	if _, resp, err := websocket.DefaultDialer.Dial(url+"/ws", nil); err == nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unmounted path: got %v", err)
+	}
+}
+
+func TestListenAndServe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	s := NewServer(WithPath("/live"))
+	go s.ListenAndServe(addr)
+	defer s.Shutdown(context.Background())
+
+	var conn *websocket.Conn
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if conn, _, err = websocket.DefaultDialer.Dial("ws://"+addr+"/live", nil); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+	}
+	defer conn.Close()
+	if id, _ := readInit(t, conn); id == "" {
+		t.Error("no client id in init")
+	}
+	if _, resp, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil); err == nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("default path: got %v", err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	s := NewServer(WithShutdownClose(4000, "maintenance"))
+	statuses := make(chan CloseStatus, 1)
+	s.HandleConnect(func(client *Client, r *http.Request) {
+		client.HandleDisconnect(func(status CloseStatus) {
+			statuses <- status
+		})
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	readInit(t, conn)
+
+	// Draining refuses new connections and waits for the connected client.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("drain: got %v, want deadline exceeded", err)
+	}
+	if !s.Draining() {
+		t.Error("server not draining")
+	}
+	if _, resp, err := websocket.DefaultDialer.Dial(url, nil); err == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("dial while draining: got %v", err)
+	}
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		shutdown <- s.Shutdown(ctx)
+	}()
+
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != 4000 || closeErr.Text != "maintenance" {
+		t.Errorf("got %v, want close 4000 maintenance", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+	select {
+	case status := <-statuses:
+		if status.Code != 4000 || status.Initiator != InitiatorServer {
+			t.Errorf("disconnect status %+v", status)
+		}
+	case <-time.After(time.Second):
+		t.Error("disconnect handler not called")
+	}
+	if err := s.Shutdown(context.Background()); !errors.Is(err, ErrServerClosed) {
+		t.Errorf("second shutdown: %v", err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	s := NewServer()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readInit(t, conn)
+
+	drained := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		drained <- s.Drain(ctx)
+	}()
+	conn.Close()
+	if err := <-drained; err != nil {
+		t.Errorf("drain: %v", err)
+	}
+}
+
+func TestShutdownNamespaceDisconnect(t *testing.T) {
+	s := NewServer()
+	ns := s.Namespace("/chat")
+	stream := newClient(ns.hub, nil, "stream")
+	ns.hub.mu.Lock()
+	ns.hub.clients[stream.id] = stream
+	ns.hub.mu.Unlock()
+	stream.HandleDisconnect(func(status CloseStatus) {
+		s.Namespace("/lobby")
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(context.Background()) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("shutdown deadlocked in disconnect handler")
+	}
+}
@@ -0,0 +1,115 @@
+package axion
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Name of the query parameter a reconnecting client uses to present its resume token.
+const ResumeTokenParam = "resume"
+
+// Enables session recovery. A client which loses its connection is kept with its id, rooms and context
+// for the grace period and can resume its session by reconnecting with the resume token from its Init message.
+// Up to replayBufferSize messages sent to the client while it was away are replayed after the reconnect.
+func WithSessionRecovery(gracePeriod time.Duration, replayBufferSize int) Option {
+	return func(o *serverOptions) {
+		o.sessionGracePeriod = gracePeriod
+		o.replayBufferSize = replayBufferSize
+	}
+}
+
+// Returns the token the client can use to resume its session. Empty if session recovery is disabled.
+func (c *Client) ResumeToken() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.resumeToken
+}
+
+// Reports whether the client lost its connection and waits for a reconnect.
+func (c *Client) Detached() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.detached
+}
+
+// Appends a message to the replay buffer and drops the oldest messages exceeding the buffer size.
+func (c *Client) bufferReplay(replay []WsMessage, message WsMessage) []WsMessage {
+	size := c.hub.server.options.replayBufferSize
+	if size <= 0 {
+		return replay
+	}
+	replay = append(replay, message)
+	if len(replay) > size {
+		replay = replay[len(replay)-size:]
+	}
+	return replay
+}
+
+func (h *Hub) recoveryEnabled() bool {
+	return h.server.options.sessionGracePeriod > 0
+}
+
+// Hands a new connection to the write pump of the client and issues a fresh resume token.
+// The caller starts the read pump. Must be called with the hub lock held.
+func (h *Hub) attachClient(client *Client, conn *websocket.Conn) {
+	client.mu.Lock()
+	if client.graceTimer != nil {
+		client.graceTimer.Stop()
+		client.graceTimer = nil
+	}
+	delete(h.sessions, client.resumeToken)
+	if h.recoveryEnabled() {
+		client.resumeToken = uuid.New().String()
+		h.sessions[client.resumeToken] = client
+	}
+	client.conn = conn
+	client.detached = false
+	client.mu.Unlock()
+
+	// Every connection starts with version 1 and negotiates again after the Init message. Clients which
+	// do not negotiate keep every capability, as they predate the handshake. The codec is the one of the
+	// subprotocol the connection agreed on.
+	client.version.Store(ProtocolV1)
+	client.negotiated.Store(false)
+	client.features.Store(uint32(h.server.capabilities()))
+	if codec := h.server.codecFor(conn.Subprotocol()); codec != nil {
+		client.codec.Store(&codec)
+	} else {
+		client.codec.Store(nil)
+	}
+
+	client.attach <- conn
+}
+
+// Continues the session of a client which reconnected with its resume token. A connection the client
+// might still hold (e.g. a half-open socket after a network handover) is closed. Must be called with the hub lock held.
+func (h *Hub) resumeClient(client *Client, conn *websocket.Conn) {
+	client.logger.Info("client resumed")
+
+	client.mu.RLock()
+	previous, detached := client.conn, client.detached
+	client.mu.RUnlock()
+	if !detached {
+		previous.Close()
+	}
+	h.attachClient(client, conn)
+}
+
+// Keeps a client which lost its connection for the grace period. Must be called with the hub lock held.
+func (h *Hub) detachClient(client *Client) {
+	client.logger.Info("client detached", "grace_period", h.server.options.sessionGracePeriod)
+
+	client.mu.Lock()
+	client.detached = true
+	client.graceTimer = time.AfterFunc(h.server.options.sessionGracePeriod, func() {
+		select {
+		case h.expire <- client:
+		case <-h.done:
+		}
+	})
+	client.mu.Unlock()
+
+	client.attach <- nil
+}
@@ -0,0 +1,133 @@
+package axion
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Reads the Init message of a connection and returns the client id and resume token.
+func readInit(t *testing.T, conn *websocket.Conn) (string, string) {
+	_, p, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	init, err := DecodeFrame(ProtocolV1, p)
+	if err != nil || init.Type != SigInit || len(init.Payload) < 5 {
+		t.Fatalf("expected init, got %q", p)
+	}
+	// Capabilities (4 bytes) and the supported versions precede the token.
+	return init.ClientId, string(init.Payload[5+int(init.Payload[4]):])
+}
+
+func TestSessionResume(t *testing.T) {
+	s := NewServer(WithSessionRecovery(500*time.Millisecond, 2))
+	clients := make(chan *Client, 2)
+	resumed := make(chan struct{}, 1)
+	s.HandleConnect(func(client *Client, r *http.Request) {
+		client.HandleResume(func() { resumed <- struct{}{} })
+		clients <- client
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, token := readInit(t, conn)
+	client := <-clients
+	room := s.CreateRoom()
+	client.JoinRoom(room)
+
+	// Dropping the connection keeps the session for the grace period.
+	conn.UnderlyingConn().Close()
+	for deadline := time.Now().Add(time.Second); !client.Detached(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("client was not detached")
+		}
+	}
+	for _, message := range []string{"one", "two", "three"} {
+		client.Send(websocket.TextMessage, []byte(message))
+	}
+
+	conn, _, err = websocket.DefaultDialer.Dial(url+"?"+ResumeTokenParam+"="+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	resumedId, newToken := readInit(t, conn)
+	if resumedId != id || newToken == token || newToken == "" {
+		t.Errorf("resumed as %s with token %q, want %s with a fresh token", resumedId, newToken, id)
+	}
+	// The replay buffer holds the last two messages.
+	for _, want := range []string{"two", "three"} {
+		if _, p, err := conn.ReadMessage(); err != nil || string(p) != want {
+			t.Errorf("got %q, %v, want %s", p, err, want)
+		}
+	}
+
+	select {
+	case <-resumed:
+	case <-time.After(time.Second):
+		t.Error("resume handler not called")
+	}
+	if len(clients) != 0 {
+		t.Error("connect handler called for the resumed session")
+	}
+	if rooms := client.Rooms(); len(rooms) != 1 || rooms[0] != room {
+		t.Errorf("resumed session is in %v", rooms)
+	}
+
+	// Once the grace period is over the session ends and its token is no longer accepted.
+	conn.UnderlyingConn().Close()
+	for deadline := time.Now().Add(2 * time.Second); len(s.Clients()) > 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("session did not expire")
+		}
+	}
+	conn, _, err = websocket.DefaultDialer.Dial(url+"?"+ResumeTokenParam+"="+newToken, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if newId, _ := readInit(t, conn); newId == id {
+		t.Error("session resumed after it expired")
+	}
+}
+
+func TestSessionClosedByClient(t *testing.T) {
+	s := NewServer(WithSessionRecovery(time.Minute, 2))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, token := readInit(t, conn)
+
+	// A close frame of the client ends the session right away, its token is no longer accepted.
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	conn.ReadMessage()
+	conn.Close()
+	for deadline := time.Now().Add(2 * time.Second); len(s.Clients()) > 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("session was not ended")
+		}
+	}
+	conn, _, err = websocket.DefaultDialer.Dial(url+"?"+ResumeTokenParam+"="+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if newId, _ := readInit(t, conn); newId == id {
+		t.Error("session resumed after the client closed it")
+	}
+}
@@ -0,0 +1,163 @@
+package axion
+
+import (
+	"encoding/binary"
+
+	"github.com/gorilla/websocket"
+)
+
+// Handles the stream frames of a connection:
+//
+//	OpenStreamMessage  | stream id (4 bytes) | namespace
+//	CloseStreamMessage | stream id (4 bytes)
+//	StreamMessage      | stream id (4 bytes) | websocket message type (1 byte) | content
+func (c *Client) readStreamMessage(kind int, rest []byte) {
+	if c.parent != nil {
+		c.clientError("nested streams are not supported")
+		return
+	}
+	if len(rest) < 4 {
+		c.clientError("malformed stream message")
+		return
+	}
+	streamId := binary.BigEndian.Uint32(rest[:4])
+	rest = rest[4:]
+
+	switch kind {
+	case OpenStreamMessage:
+		c.openStream(streamId, string(rest))
+	case CloseStreamMessage:
+		if stream, ok := c.getStream(streamId); ok {
+			c.closeStream(stream, CloseStatus{Code: websocket.CloseNormalClosure, Initiator: InitiatorClient})
+		}
+	case StreamMessage:
+		stream, ok := c.getStream(streamId)
+		if !ok {
+			c.clientError("stream not found")
+			return
+		}
+		if len(rest) < 1 {
+			c.clientError("malformed stream message")
+			return
+		}
+		msgType, content := int(rest[0]), rest[1:]
+		if !c.checkStreamRateLimit(msgType, content) {
+			c.rateLimited(ScopeKind, msgType, content)
+			return
+		}
+		stream.handleMessage(msgType, content)
+	}
+}
+
+func (c *Client) getStream(streamId uint32) (*Client, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	stream, ok := c.streams[streamId]
+	return stream, ok
+}
+
+// Opens the namespace as a stream if the namespace authorizes the connection.
+func (c *Client) openStream(streamId uint32, name string) {
+	if !c.Capabilities().Has(CapStreams) {
+		c.rejectStream(streamId, "streams not negotiated")
+		return
+	}
+	ns, ok := c.hub.server.getNamespace(name)
+	if !ok || name == DefaultNamespace {
+		c.rejectStream(streamId, "namespace not found")
+		return
+	}
+	if _, ok := c.getStream(streamId); ok {
+		c.rejectStream(streamId, "stream id in use")
+		return
+	}
+	if err := ns.handlers.authorizeHandler(c, c.request); err != nil {
+		c.rejectStream(streamId, err.Error())
+		return
+	}
+
+	stream := newClient(ns.hub, nil, c.id)
+	stream.parent = c
+	stream.streamId = streamId
+	stream.request = c.request
+	stream.done = c.done
+	stream.logger = c.logger.With("namespace", name, "stream_id", streamId)
+
+	ns.hub.mu.Lock()
+	if _, ok := ns.hub.clients[c.id]; ok {
+		ns.hub.mu.Unlock()
+		c.rejectStream(streamId, "namespace already open")
+		return
+	}
+	ns.hub.clients[c.id] = stream
+	ns.hub.mu.Unlock()
+
+	c.mu.Lock()
+	c.streams[streamId] = stream
+	c.mu.Unlock()
+
+	stream.logger.Info("stream opened")
+	c.SendMessage(NewStreamOpenedMessage(streamId))
+	stream.protect("connect", func() {
+		ns.handlers.connectHandler(stream, c.request)
+	})
+}
+
+func (c *Client) rejectStream(streamId uint32, reason string) {
+	c.logger.Debug("stream rejected", "stream_id", streamId, "reason", reason)
+	c.SendMessage(NewStreamRejectedMessage(streamId, reason))
+}
+
+// Ends the stream and tells the client with a StreamClosed message. Does nothing if the stream is already closed.
+func (c *Client) closeStream(stream *Client, status CloseStatus) {
+	c.mu.Lock()
+	if c.streams[stream.streamId] != stream {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.streams, stream.streamId)
+	c.mu.Unlock()
+
+	stream.setCloseStatus(status)
+	c.SendMessage(NewStreamClosedMessage(stream.streamId, status.Code, status.Reason))
+	stream.hub.unregisterStream(stream)
+}
+
+// Ends all streams of the connection.
+func (c *Client) closeStreams() {
+	c.mu.Lock()
+	streams := c.streams
+	c.streams = make(map[uint32]*Client)
+	c.mu.Unlock()
+
+	for _, stream := range streams {
+		stream.setCloseStatus(c.CloseReason())
+		stream.hub.unregisterStream(stream)
+	}
+}
+
+// Returns the namespace the client belongs to.
+func (c *Client) Namespace() *Namespace {
+	return c.hub.namespace
+}
+
+// Returns the client of the connection carrying the stream, or the client itself if it is not a stream.
+func (c *Client) Connection() *Client {
+	if c.parent != nil {
+		return c.parent
+	}
+	return c
+}
+
+func (h *Hub) unregisterStream(stream *Client) {
+	h.mu.Lock()
+	if h.clients[stream.id] != stream {
+		h.mu.Unlock()
+		return
+	}
+	stream.logger.Info("stream closed")
+	h.removeClient(stream, "closed")
+	h.mu.Unlock()
+
+	stream.onDisconnect()
+}
@@ -0,0 +1,109 @@
+package axion
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestStreams(t *testing.T) {
+	s := NewServer()
+	echo := func(prefix string) func(client *Client, r *http.Request) {
+		return func(client *Client, r *http.Request) {
+			client.HandleText(func(message string) {
+				client.Send(websocket.TextMessage, []byte(prefix+message))
+			})
+		}
+	}
+	s.HandleConnect(echo("default: "))
+	chat := s.Namespace("/chat")
+	streams := make(chan *Client, 1)
+	closed := make(chan CloseStatus, 1)
+	chat.HandleConnect(func(client *Client, r *http.Request) {
+		echo("chat: ")(client, r)
+		client.HandleDisconnect(func(status CloseStatus) { closed <- status })
+		streams <- client
+	})
+	s.Namespace("/admin").HandleAuthorize(func(client *Client, r *http.Request) error {
+		return errors.New("forbidden")
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	write := func(kind uint32, payload []byte) {
+		conn.WriteMessage(websocket.BinaryMessage, append(binary.BigEndian.AppendUint32(nil, kind), payload...))
+	}
+	read := func() (uint32, string) {
+		msgType, p, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msgType == websocket.TextMessage {
+			return 0, string(p)
+		}
+		return binary.BigEndian.Uint32(p), string(p[4:])
+	}
+	streamPayload := func(streamId uint32, rest string) []byte {
+		return append(binary.BigEndian.AppendUint32(nil, streamId), rest...)
+	}
+	id, _ := readInit(t, conn)
+
+	tests := []struct {
+		name    string
+		stream  uint32
+		want    uint32
+		payload string
+	}{
+		{"/chat", 1, SigStreamOpened, ""},
+		{"/admin", 2, SigStreamRejected, "forbidden"},
+		{"/missing", 3, SigStreamRejected, "namespace not found"},
+		{"/chat", 1, SigStreamRejected, "stream id in use"},
+	}
+	for _, test := range tests {
+		write(OpenStreamMessage, streamPayload(test.stream, test.name))
+		kind, payload := read()
+		if kind != test.want || payload != string(streamPayload(test.stream, test.payload)) {
+			t.Errorf("open %s as %d: got %#x %q", test.name, test.stream, kind, payload)
+		}
+	}
+	stream := <-streams
+	if stream.Id() != id || stream.Namespace() != chat || stream.Connection().Namespace() != s.hub.namespace {
+		t.Errorf("stream client %s in %v", stream.Id(), stream.Namespace())
+	}
+
+	write(StreamMessage, streamPayload(1, "\x01hi"))
+	if kind, payload := read(); kind != StreamMessage || payload != string(streamPayload(1, "\x01chat: hi")) {
+		t.Errorf("stream message: got %#x %q", kind, payload)
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte("hi"))
+	if _, text := read(); text != "default: hi" {
+		t.Errorf("connection message: got %q", text)
+	}
+
+	write(CloseStreamMessage, streamPayload(1, ""))
+	if kind, payload := read(); kind != SigStreamClosed || payload != string(streamPayload(1, "\x03\xe8")) {
+		t.Errorf("close stream: got %#x %q", kind, payload)
+	}
+	select {
+	case status := <-closed:
+		if status.Code != websocket.CloseNormalClosure || status.Initiator != InitiatorClient {
+			t.Errorf("stream closed with %+v", status)
+		}
+	case <-time.After(time.Second):
+		t.Error("disconnect handler of the stream not called")
+	}
+	if _, ok := chat.GetClientById(id); ok {
+		t.Error("closed stream still registered in its namespace")
+	}
+}
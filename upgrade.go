@@ -0,0 +1,129 @@
+package axion
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type upgradeOptions struct {
+	allowedOrigins    []string
+	readBufferSize    int
+	writeBufferSize   int
+	handshakeTimeout  time.Duration
+	subprotocols      []string
+	enableCompression bool
+	errorHandler      func(w http.ResponseWriter, r *http.Request, status int, reason error)
+}
+
+// Sets the origins browsers may connect from. Patterns are matched against the host of the Origin header,
+// or against scheme and host if they contain "://", and may use the wildcards of path.Match,
+// e.g. "app.example.com", "https://*.example.com" or "localhost:*". "*" allows every origin.
+// By default only requests from the host the server is reached on are accepted.
+// Requests without an Origin header, which browsers always send, are accepted.
+func WithAllowedOrigins(patterns ...string) Option {
+	return func(o *serverOptions) {
+		o.upgrade.allowedOrigins = patterns
+	}
+}
+
+// Sets the sizes of the read and write buffers of connections. Defaults to 1024 bytes each.
+func WithBufferSizes(read int, write int) Option {
+	return func(o *serverOptions) {
+		o.upgrade.readBufferSize = read
+		o.upgrade.writeBufferSize = write
+	}
+}
+
+// Sets the time the opening handshake may take.
+func WithHandshakeTimeout(timeout time.Duration) Option {
+	return func(o *serverOptions) {
+		o.upgrade.handshakeTimeout = timeout
+	}
+}
+
+// Sets the subprotocols the server supports in order of preference. The first one offered by the client is selected.
+func WithSubprotocols(protocols ...string) Option {
+	return func(o *serverOptions) {
+		o.upgrade.subprotocols = protocols
+	}
+}
+
+// Negotiates per-message compression with clients supporting it.
+func WithCompression() Option {
+	return func(o *serverOptions) {
+		o.upgrade.enableCompression = true
+	}
+}
+
+// Sets the function responding to refused upgrade requests, e.g. failed authentication, forbidden origins
+// or a draining server. Defaults to http.Error.
+func WithUpgradeErrorHandler(fun func(w http.ResponseWriter, r *http.Request, status int, reason error)) Option {
+	return func(o *serverOptions) {
+		o.upgrade.errorHandler = fun
+	}
+}
+
+func (s *Server) newUpgrader() *websocket.Upgrader {
+	opts := s.options.upgrade
+	var subprotocols []string
+	for _, codec := range s.options.codecs {
+		subprotocols = append(subprotocols, codec.Name())
+	}
+	return &websocket.Upgrader{
+		ReadBufferSize:    opts.readBufferSize,
+		WriteBufferSize:   opts.writeBufferSize,
+		HandshakeTimeout:  opts.handshakeTimeout,
+		Subprotocols:      append(subprotocols, opts.subprotocols...),
+		EnableCompression: opts.enableCompression,
+		CheckOrigin:       s.checkOrigin,
+		Error:             s.upgradeError,
+	}
+}
+
+func (s *Server) upgradeError(w http.ResponseWriter, r *http.Request, status int, reason error) {
+	if s.options.upgrade.errorHandler != nil {
+		s.options.upgrade.errorHandler(w, r, status, reason)
+		return
+	}
+	http.Error(w, reason.Error(), status)
+}
+
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	allowed := strings.EqualFold(u.Host, r.Host)
+	if len(s.options.upgrade.allowedOrigins) > 0 {
+		allowed = originAllowed(s.options.upgrade.allowedOrigins, u)
+	}
+	if !allowed {
+		s.options.logger.Debug("origin refused", "remote_addr", r.RemoteAddr, "origin", origin)
+	}
+	return allowed
+}
+
+func originAllowed(patterns []string, origin *url.URL) bool {
+	host := strings.ToLower(origin.Host)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		target := host
+		if strings.Contains(pattern, "://") {
+			target = strings.ToLower(origin.Scheme) + "://" + host
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
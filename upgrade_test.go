@@ -0,0 +1,35 @@
+package axion
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{nil, "", true},
+		{nil, "http://example.com", true},
+		{nil, "http://evil.com", false},
+		{[]string{"*"}, "http://evil.com", true},
+		{[]string{"https://*.example.com"}, "https://app.example.com", true},
+		{[]string{"https://*.example.com"}, "http://app.example.com", false},
+		{[]string{"https://*.example.com"}, "https://example.com.evil.com", false},
+		{[]string{"localhost:*"}, "http://localhost:3000", true},
+		{[]string{"App.Example.com"}, "https://app.example.com", true},
+	}
+
+	for _, test := range tests {
+		s := NewServer(WithAllowedOrigins(test.allowed...))
+		r := httptest.NewRequest("GET", "http://example.com/ws", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if got := s.checkOrigin(r); got != test.want {
+			t.Errorf("allowed %v, origin %q: got %v, want %v", test.allowed, test.origin, got, test.want)
+		}
+	}
+}